@@ -18,7 +18,9 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 
 	api "github.com/reinventingscience/ivcap-core-api/http/artifact"
 	meta "github.com/reinventingscience/ivcap-core-api/http/metadata"
@@ -28,8 +30,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	sdk "github.com/reinventingscience/ivcap-cli/pkg"
 	a "github.com/reinventingscience/ivcap-cli/pkg/adapter"
@@ -57,6 +60,8 @@ func init() {
 	// DOWNLOAD
 	artifactCmd.AddCommand(downloadArtifactCmd)
 	downloadArtifactCmd.Flags().StringVarP(&outputFile, "file", "f", "", "File to write content to [stdout]")
+	downloadArtifactCmd.Flags().IntVar(&parallelDownloads, "parallel", 1, "Number of byte ranges to download concurrently (files only)")
+	downloadArtifactCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Max. transfer rate, e.g. '5MB/s' [unlimited]")
 
 	// CREATE
 	artifactCmd.AddCommand(createArtifactCmd)
@@ -65,6 +70,12 @@ func init() {
 	createArtifactCmd.Flags().StringVarP(&inputFile, "file", "f", "", "Path to file containing artifact content")
 	createArtifactCmd.Flags().StringVarP(&contentType, "content-type", "t", "", "Content type of artifact")
 	createArtifactCmd.Flags().Int64Var(&chunkSize, "chunk-size", DEF_CHUNK_SIZE, "Chunk size for splitting large files")
+	createArtifactCmd.Flags().IntVar(&parallelUploads, "parallel", 1, "Number of parts to upload concurrently (files only)")
+	createArtifactCmd.Flags().StringVar(&checksum, "checksum", sdk.CHECKSUM_SHA256, "Checksum algorithm to verify uploaded content (sha256, md5, none)")
+	createArtifactCmd.Flags().BoolVar(&recursive, "recursive", false, "Upload all files in a directory, each as a separate artifact")
+	createArtifactCmd.Flags().IntVar(&uploadJobs, "jobs", DEF_UPLOAD_JOBS, "Max. number of files uploaded concurrently with '--recursive'")
+	createArtifactCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Max. transfer rate, e.g. '5MB/s' [unlimited]")
+	createArtifactCmd.Flags().BoolVar(&detectOnly, "detect-only", false, "Only show the detected content type of the file(s) without uploading")
 
 	// UPLOAD
 	artifactCmd.AddCommand(uploadArtifactCmd)
@@ -73,10 +84,12 @@ func init() {
 	uploadArtifactCmd.Flags().StringVarP(&inputFile, "file", "f", "", "Path to file containing artifact content")
 	uploadArtifactCmd.Flags().StringVarP(&contentType, "content-type", "t", "", "Content type of artifact")
 	uploadArtifactCmd.Flags().Int64Var(&chunkSize, "chunk-size", DEF_CHUNK_SIZE, "Chunk size for splitting large files")
+	uploadArtifactCmd.Flags().StringVar(&checksum, "checksum", sdk.CHECKSUM_SHA256, "Checksum algorithm to verify uploaded content (sha256, md5, none)")
+	uploadArtifactCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Max. transfer rate, e.g. '5MB/s' [unlimited]")
 
-	// COLLECTION
-	// artifactCmd.AddCommand(addArtifactToCollectionCmd)
-	// artifactCmd.AddCommand(removeArtifactFromCollectionCmd)
+	// DELETE
+	artifactCmd.AddCommand(deleteArtifactCmd)
+	deleteArtifactCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Delete without asking for confirmation")
 
 	// ADD METADATA
 	artifactCmd.AddCommand(addArtifactMetadataCmd)
@@ -86,6 +99,11 @@ func init() {
 
 const DEF_CHUNK_SIZE = 1000000 // -1 ... no chunking
 
+// Timeout in seconds for commands transferring content, which can take a long time
+const DEF_TRANSFER_TIMEOUT = 100000
+
+const DEF_UPLOAD_JOBS = 4
+
 type ArtifactPostResponse struct {
 	// Artifact ID
 	ID string `form:"id" json:"id" xml:"id"`
@@ -108,6 +126,13 @@ var (
 	metaFile           string
 	contentType        string
 	chunkSize          int64
+	parallelUploads    int
+	parallelDownloads  int
+	recursive          bool
+	uploadJobs         int
+	checksum           string
+	assumeYes          bool
+	detectOnly         bool
 
 	artifactCmd = &cobra.Command{
 		Use:     "artifact",
@@ -129,7 +154,7 @@ var (
 			if limit > 0 {
 				req.Limit = limit
 			}
-			if res, err := sdk.ListArtifactsRaw(context.Background(), req, CreateAdapter(true), logger); err == nil {
+			if res, err := sdk.ListArtifactsRaw(cmd.Context(), req, CreateAdapter(true), logger); err == nil {
 				switch outputFormat {
 				case "json":
 					a.ReplyPrinter(res, false)
@@ -159,14 +184,14 @@ var (
 
 			switch outputFormat {
 			case "json", "yaml":
-				if res, err := sdk.ReadArtifactRaw(context.Background(), req, adapter, logger); err == nil {
+				if res, err := sdk.ReadArtifactRaw(cmd.Context(), req, adapter, logger); err == nil {
 					a.ReplyPrinter(res, outputFormat == "yaml")
 				} else {
 					return err
 				}
 			default:
-				if artifact, err := sdk.ReadArtifact(context.Background(), req, adapter, logger); err == nil {
-					if meta, _, err := sdk.ListMetadata(context.Background(), recordID, "", nil, adapter, logger); err == nil {
+				if artifact, err := sdk.ReadArtifact(cmd.Context(), req, adapter, logger); err == nil {
+					if meta, _, err := sdk.ListMetadata(cmd.Context(), recordID, "", nil, adapter, logger); err == nil {
 						printArtifact(artifact, meta, false)
 					} else {
 						return err
@@ -186,41 +211,77 @@ var (
 		RunE:  downloadArtifact,
 	}
 
+	deleteArtifactCmd = &cobra.Command{
+		Use:   "delete [flags] artifactID [artifactID ...|-]",
+		Short: "Delete artifacts together with their content",
+		Long: `Deletes artifacts together with their content. Unfinished uploads to them
+are terminated first. Artifact IDs can be history tokens (@n), and '-' reads
+further IDs from stdin, one per line, which requires '--yes'.`,
+		Aliases: []string{"rm"},
+		Args:    cobra.MinimumNArgs(1),
+		RunE:    deleteArtifacts,
+	}
+
 	createArtifactCmd = &cobra.Command{
-		Use:   "create [key=value key=value] -f file|-",
+		Use:   "create [key=value key=value] -f file|- | --recursive dir",
 		Short: "Create a new artifact",
 
-		Run: func(cmd *cobra.Command, args []string) {
-			var reader io.Reader
-			var size int64
-			reader, contentType, size = getReader(inputFile, contentType)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkChunkSize(cmd); err != nil {
+				return err
+			}
+			if recursive {
+				dir := inputFile
+				if len(args) > 0 {
+					dir = args[0]
+				}
+				if dir == "" || dir == "-" {
+					return newExitError(EXIT_USAGE, "missing directory to upload")
+				}
+				if detectOnly {
+					return printDetectedContentTypes(dir)
+				}
+				ctxt, stats, err := startTransfer(cmd.Context())
+				if err != nil {
+					return err
+				}
+				err = createArtifactsFromDir(ctxt, dir)
+				printTransferStats(stats)
+				return err
+			}
+			if detectOnly {
+				return printDetectedContentTypes(inputFile)
+			}
+			reader, contentType, size, err := getReader(inputFile, contentType)
+			if err != nil {
+				return err
+			}
 			logger.Debug("create artifact", log.String("content-type", contentType), log.String("inputFile", inputFile))
-			adapter := CreateAdapterWithTimeout(true, 100000)
+			digest, err := getChecksumHash()
+			if err != nil {
+				return err
+			}
+			ctxt, stats, err := startTransfer(cmd.Context())
+			if err != nil {
+				return err
+			}
+			adapter := CreateAdapterWithTimeout(true, DEF_TRANSFER_TIMEOUT)
 			req := &sdk.CreateArtifactRequest{
 				Name:       artifactName,
 				Size:       size,
 				Collection: artifactCollection,
 			}
-			ctxt := context.Background()
-			resp, err := sdk.CreateArtifact(ctxt, req, contentType, size, nil, adapter, logger)
+			artifactID, _, err := createFileArtifact(ctxt, reader, inputFile, contentType, size, req,
+				GetActiveContext().URL, digest, parallelUploads, silent, adapter)
 			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while creating record for '%s'- %v", inputFile, err))
-				return
-			}
-			artifactID := *resp.ID
-			if !silent {
-				fmt.Printf("Created artifact '%s'\n", artifactID)
-			}
-			path, err := (*adapter).GetPath(*resp.Data.Self)
-			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while parsing API reply - %v", err))
-				return
+				return err
 			}
-			upload(ctxt, reader, artifactID, path, size, 0, adapter)
+			printTransferStats(stats)
 			if silent {
 				fmt.Printf("%s\n", artifactID)
+				return nil
 			}
-
+			return printUploadedArtifact(ctxt, artifactID, adapter)
 		},
 	}
 
@@ -230,155 +291,454 @@ var (
 		Aliases: []string{"resume"},
 		Args:    cobra.ExactArgs(1),
 
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkChunkSize(cmd); err != nil {
+				return err
+			}
 			artifactID := args[0]
-			reader, contentType, size := getReader(inputFile, contentType)
+			reader, contentType, size, err := getReader(inputFile, contentType)
+			if err != nil {
+				return err
+			}
 			logger.Debug("upload artifact", log.String("content-type", contentType), log.String("inputFile", inputFile))
-			adapter := CreateAdapter(true)
-			ctxt := context.Background()
-
-			offset := int64(0)
+			digest, err := getChecksumHash()
+			if err != nil {
+				return err
+			}
+			adapter := CreateAdapterWithTimeout(true, DEF_TRANSFER_TIMEOUT)
+			ctxt := cmd.Context()
 
 			read_req := &sdk.ReadArtifactRequest{Id: artifactID}
 			readResp, err := sdk.ReadArtifact(ctxt, read_req, adapter, logger)
 			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while getting a status update on '%s' - %v", artifactID, err))
-				return
+				return fmt.Errorf("while getting a status update on '%s' - %w", artifactID, err)
 			}
 			path, err := (*adapter).GetPath(*readResp.Data.Self)
 			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while parsing API reply - %v", err))
-				return
+				return fmt.Errorf("while parsing API reply - %w", err)
 			}
-
-			headers := map[string]string{
-				"Tus-Resumable": "1.0.0",
-			}
-			pyld, err := (*adapter).Head(ctxt, path, &headers, logger)
+			offset, err := getUploadOffset(ctxt, path, adapter)
 			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while checking on upload status of artifact '%s' - %v", artifactID, err))
-				return
-			}
-			offset, err = strconv.ParseInt(pyld.Header("Upload-Offset"), 10, 64)
-			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("problems parsing 'Upload-Offset' in return header '%s' - %v", pyld.Header("Upload-Offset"), err))
-				return
+				return fmt.Errorf("while checking on upload status of artifact '%s' - %w", artifactID, err)
 			}
 
 			if size > 0 && offset >= size {
 				// already done
-				cobra.CompErrorln(fmt.Sprintf("Artifact '%s' already fully uploaded\n", artifactID))
-				return
+				fmt.Printf("Artifact '%s' already fully uploaded\n", artifactID)
+				return nil
 			}
-
-			if err = upload(ctxt, reader, artifactID, path, size, offset, adapter); err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while uploading artifact '%s' - %v", artifactID, err))
+			tctxt, stats, err := startTransfer(ctxt)
+			if err != nil {
+				return err
+			}
+			if err = transferContent(tctxt, reader, inputFile, artifactID, path, size, offset, digest, silent, adapter); err != nil {
+				return err
+			}
+			printTransferStats(stats)
+			if silent {
+				return nil
 			}
+			return printUploadedArtifact(ctxt, artifactID, adapter)
 		},
 	}
 
-	// NOT SURE HOW WE BEST HANDLE THAT
-	//
-	// addArtifactToCollectionCmd = &cobra.Command{
-	// 	Use:     "add-to-collection artifactID collectionName",
-	// 	Short:   "Add artifact to a collection",
-	// 	Aliases: []string{"add-collection"},
-	// 	Args:    cobra.ExactArgs(2),
-
-	// 	Run: func(cmd *cobra.Command, args []string) {
-	// 		artifactID := args[0]
-	// 		collectionName := args[1]
-	// 		logger.Debug("add collection", log.String("artifactID", artifactID), log.String("collectionName", collectionName))
-	// 		adapter := CreateAdapter(true)
-	// 		ctxt := context.Background()
-	// 		_, err := sdk.AddArtifactToCollection(ctxt, artifactID, collectionName, adapter, logger)
-	// 		if err != nil {
-	// 			cobra.CompErrorln(fmt.Sprintf("while adding artifact '%s' to collection(s) '%s' - %v", artifactID, collectionName, err))
-	// 			return
-	// 		}
-	// 	},
-	// }
-
-	// removeArtifactFromCollectionCmd = &cobra.Command{
-	// 	Use:     "remove-from-collection artifactID collectionName",
-	// 	Short:   "Remove artifact from a collection",
-	// 	Aliases: []string{"remove-collection", "rm-collection"},
-	// 	Args:    cobra.ExactArgs(2),
-
-	// 	Run: func(cmd *cobra.Command, args []string) {
-	// 		artifactID := args[0]
-	// 		collectionName := args[1]
-	// 		logger.Debug("rm collection", log.String("artifactID", artifactID), log.String("collectionName", collectionName))
-	// 		adapter := CreateAdapter(true)
-	// 		ctxt := context.Background()
-	// 		_, err := sdk.RemoveArtifactToCollection(ctxt, artifactID, collectionName, adapter, logger)
-	// 		if err != nil {
-	// 			cobra.CompErrorln(fmt.Sprintf("while removing artifact '%s' from collection(s) '%s' - %v", artifactID, collectionName, err))
-	// 			return
-	// 		}
-	// 	},
-	// }
-
 	addArtifactMetadataCmd = &cobra.Command{
 		Use:     "add-metadata artifactID schemaName -f meta.json",
-		Short:   "Add artifact to a comma separated list of collections",
+		Short:   "Add metadata to an artifact",
 		Aliases: []string{"add-meta"},
 		Args:    cobra.ExactArgs(2),
 
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			artifactID := args[0]
 			schemaName := args[1]
 			logger.Debug("add meta", log.String("artifactID", artifactID), log.String("schemaName", schemaName),
 				log.String("metaFile", metaFile))
-			reader, _, size := getReader(metaFile, "application/json")
+			reader, _, size, err := getReader(metaFile, "application/json")
+			if err != nil {
+				return err
+			}
 
 			adapter := CreateAdapter(true)
-			ctxt := context.Background()
-			_, err := sdk.AddArtifactMeta(ctxt, artifactID, schemaName, reader, size, adapter, logger)
+			ctxt := cmd.Context()
+			_, err = sdk.AddArtifactMeta(ctxt, artifactID, schemaName, reader, size, adapter, logger)
 			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while adding metadata '%s' to artifact '%s' - %v", schemaName, artifactID, err))
-				return
+				return fmt.Errorf("while adding metadata '%s' to artifact '%s' - %w", schemaName, artifactID, err)
 			}
+			return nil
 		},
 	}
 
 	removeArtifactMetadataCmd = &cobra.Command{
 		Use:     "remove-metadata artifactID schemaName",
-		Short:   "Remove artifact from a comma separated list of collections",
-		Aliases: []string{"remove-collection", "rm-collection"},
+		Short:   "Revoke all metadata records of a schema from an artifact",
+		Aliases: []string{"remove-meta", "rm-meta"},
 		Args:    cobra.ExactArgs(2),
 
-		Run: func(cmd *cobra.Command, args []string) {
-			artifactID := args[0]
-			collectionName := args[1]
-			logger.Debug("rm collection", log.String("artifactID", artifactID), log.String("collectionName", collectionName))
+		RunE: func(cmd *cobra.Command, args []string) error {
+			artifactID := GetHistory(args[0])
+			schemaName := args[1]
+			logger.Debug("rm meta", log.String("artifactID", artifactID), log.String("schemaName", schemaName))
 			adapter := CreateAdapter(true)
-			ctxt := context.Background()
-			_, err := sdk.RemoveArtifactToCollection(ctxt, artifactID, collectionName, adapter, logger)
+			ctxt := cmd.Context()
+			records, err := sdk.ListAllMetadata(ctxt, artifactID, schemaName, adapter, logger)
 			if err != nil {
-				cobra.CompErrorln(fmt.Sprintf("while removing artifact '%s' from collection(s) '%s' - %v", artifactID, collectionName, err))
-				return
+				return fmt.Errorf("while listing metadata '%s' of artifact '%s' - %w", schemaName, artifactID, err)
 			}
+			found := false
+			for _, r := range records {
+				// the schema is matched as prefix, so skip any longer ones
+				if r.RecordID == nil || r.Schema == nil || *r.Schema != schemaName {
+					continue
+				}
+				found = true
+				if _, err := sdk.RevokeMetadata(ctxt, *r.RecordID, adapter, logger); err != nil {
+					return fmt.Errorf("while removing metadata '%s' from artifact '%s' - %w", schemaName, artifactID, err)
+				}
+			}
+			if !found {
+				return newExitError(EXIT_NOT_FOUND, "artifact '%s' has no metadata '%s'", artifactID, schemaName)
+			}
+			return nil
 		},
 	}
 )
 
-func upload(
+// Creates an artifact for the 'size' bytes in 'reader' (read from 'fileName') and uploads
+// them. Files are journaled, so that an interrupted upload continues when called again
+// for the same file. In that case 'resumed' is true and 'artifactID' the earlier artifact.
+func createFileArtifact(
+	ctxt context.Context,
+	reader io.Reader,
+	fileName string,
+	contentType string,
+	size int64,
+	req *sdk.CreateArtifactRequest,
+	deployment string,
+	digest hash.Hash,
+	parallel int,
+	quiet bool,
+	adapter *a.Adapter,
+) (artifactID string, resumed bool, err error) {
+	var journalEntry *uploadJournalEntry
+	if fileName != "-" && size > 0 {
+		if journalEntry, err = newUploadJournalEntry(fileName, deployment); err != nil {
+			return
+		}
+		journalEntry.ContentType = contentType
+		var prev *uploadJournalEntry
+		var path string
+		var offset int64
+		if prev, path, offset, err = findJournaledUpload(ctxt, journalEntry, adapter); err != nil {
+			return
+		} else if prev != nil {
+			if !quiet {
+				fmt.Printf("Resuming upload to artifact '%s' at %d of %d bytes\n", prev.ArtifactID, offset, prev.Size)
+			}
+			err = transferContent(ctxt, reader, fileName, prev.ArtifactID, path, size, offset, digest, quiet, adapter)
+			return prev.ArtifactID, true, err
+		}
+	}
+
+	if parallel > 1 && fileName != "-" && size > 0 {
+		if sdk.SupportsConcatenation(ctxt, adapter, logger) {
+			artifactID, err = createParallelArtifact(ctxt, fileName, contentType, size, req, digest, parallel, quiet, adapter)
+			return
+		}
+		logger.Debug("deployment doesn't support concatenation, uploading sequentially")
+	}
+
+	resp, err := sdk.CreateArtifact(ctxt, req, contentType, size, nil, adapter, logger)
+	if err != nil {
+		err = fmt.Errorf("while creating record for '%s'- %w", fileName, err)
+		return
+	}
+	artifactID = *resp.ID
+	if !quiet {
+		fmt.Printf("Created artifact '%s'\n", artifactID)
+	}
+	path, err := (*adapter).GetPath(*resp.Data.Self)
+	if err != nil {
+		err = fmt.Errorf("while parsing API reply - %w", err)
+		return
+	}
+	if journalEntry != nil {
+		journalEntry.ArtifactID = artifactID
+		journalEntry.UploadURL = *resp.Data.Self
+		if jerr := updateUploadJournal(func(j *uploadJournal) error {
+			j.Uploads = append(j.Uploads, journalEntry)
+			return nil
+		}); jerr != nil {
+			logger.Warn("cannot record upload in journal", log.Error(jerr))
+		}
+	}
+	err = transferContent(ctxt, reader, fileName, artifactID, path, size, 0, digest, quiet, adapter)
+	return
+}
+
+// Uploads 'fileName' as 'parallel' partial uploads sent concurrently, which the deployment
+// then joins into the artifact described by 'req'. Such uploads aren't journaled, as they
+// can't be resumed. If 'digest' is set, the checksum of the file is added to the artifact's
+// metadata.
+func createParallelArtifact(
+	ctxt context.Context,
+	fileName string,
+	contentType string,
+	size int64,
+	req *sdk.CreateArtifactRequest,
+	digest hash.Hash,
+	parallel int,
+	quiet bool,
+	adapter *a.Adapter,
+) (artifactID string, err error) {
+	// parts are read directly from the file, concurrently
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", fmt.Errorf("while opening data file '%s' - %w", fileName, err)
+	}
+	defer file.Close()
+	if digest != nil {
+		// parts arrive out of order, so the file needs to be read once more
+		if _, err = io.Copy(digest, io.NewSectionReader(file, 0, size)); err != nil {
+			return "", fmt.Errorf("while calculating checksum of '%s' - %w", fileName, err)
+		}
+	}
+	resp, err := sdk.UploadArtifactParallel(ctxt, req, contentType, file, size, parallel, chunkSize, checksum, adapter, quiet, logger)
+	if err != nil {
+		return "", fmt.Errorf("while uploading data file '%s' - %w", fileName, err)
+	}
+	artifactID = *resp.ID
+	if !quiet {
+		fmt.Printf("Created artifact '%s'\n", artifactID)
+	}
+	if digest != nil {
+		if _, err = sdk.AddArtifactChecksum(ctxt, artifactID, checksum, digest.Sum(nil), size, adapter, logger); err != nil {
+			return "", fmt.Errorf("while recording checksum of artifact '%s' - %w", artifactID, err)
+		}
+	}
+	return
+}
+
+// Uploads the content of 'reader' (read from 'fileName') to the upload at 'path' of
+// artifact 'artifactID', starting at 'offset'. If 'digest' is set, the checksum of the
+// content is added to the artifact's metadata.
+func transferContent(
 	ctxt context.Context,
 	reader io.Reader,
+	fileName string,
 	artifactID string,
 	path string,
 	size int64,
 	offset int64,
+	digest hash.Hash,
+	quiet bool,
 	adapter *a.Adapter,
 ) (err error) {
-	if err = sdk.UploadArtifact(ctxt, reader, size, offset, chunkSize, path, adapter, silent, logger); err != nil {
-		cobra.CompErrorln(fmt.Sprintf("while uploading data file '%s' - %v", inputFile, err))
-		return
+	var counter *countingWriter
+	if digest != nil {
+		counter = &countingWriter{w: digest}
+		reader = io.TeeReader(reader, counter)
 	}
-	if silent {
-		return
+	err = sdk.UploadArtifact(ctxt, reader, size, offset, chunkSize, checksum, path, adapter, quiet, logger)
+	if counter != nil {
+		size = counter.n
+	}
+	if err != nil {
+		recordUploadOffset(artifactID, path, adapter)
+		return fmt.Errorf("while uploading data file '%s' - %w", fileName, err)
+	}
+	if isUploadJournaled(artifactID) {
+		if err = updateUploadJournal(func(j *uploadJournal) error {
+			j.remove(artifactID)
+			return nil
+		}); err != nil {
+			logger.Warn("cannot remove completed upload from journal", log.Error(err))
+		}
+	}
+	if digest != nil {
+		if _, err = sdk.AddArtifactChecksum(ctxt, artifactID, checksum, digest.Sum(nil), size, adapter, logger); err != nil {
+			return fmt.Errorf("while recording checksum of artifact '%s' - %w", artifactID, err)
+		}
+	}
+	return
+}
+
+type manifestEntry struct {
+	Path       string `json:"path" yaml:"path"`
+	ArtifactID string `json:"artifact-id,omitempty" yaml:"artifact-id,omitempty"`
+	Size       int64  `json:"size" yaml:"size"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Uploads every file below 'dir' as an artifact, using up to 'uploadJobs' concurrent
+// uploads, and prints a manifest mapping the relative path of every file to its artifact.
+func createArtifactsFromDir(ctxt context.Context, dir string) error {
+	if _, err := getChecksumHash(); err != nil {
+		return err
+	}
+	manifest, total, err := listDirFiles(dir)
+	if err != nil {
+		return err
 	}
+	if len(manifest) == 0 {
+		return newExitError(EXIT_USAGE, "no files to upload in '%s'", dir)
+	}
+
+	adapter := CreateAdapterWithTimeout(true, DEF_TRANSFER_TIMEOUT)
+	deployment := GetActiveContext().URL
+	var bar io.Writer
+	if !silent {
+		bar = sdk.GetProgressBar(fmt.Sprintf("... uploading %d files", len(manifest)), total)
+	}
+	jobs := make(chan *manifestEntry)
+	var wg sync.WaitGroup
+	workers := uploadJobs
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				var err error
+				if e.ArtifactID, err = createDirArtifact(ctxt, dir, e, deployment, bar, adapter); err != nil {
+					e.Error = err.Error()
+					logger.Debug("upload failed", log.String("path", e.Path), log.Error(err))
+				}
+			}
+		}()
+	}
+	for _, e := range manifest {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+	if bar != nil {
+		fmt.Fprintf(os.Stderr, "\n") // To move past progress bar
+	}
+
+	failed := 0
+	for _, e := range manifest {
+		if e.Error != "" {
+			failed++
+		}
+	}
+	switch outputFormat {
+	case "json", "yaml":
+		if err := printValue(manifest, outputFormat == "yaml"); err != nil {
+			return err
+		}
+	default:
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Path", "Artifact", "Size"})
+		for _, e := range manifest {
+			id := e.ArtifactID
+			if e.Error != "" {
+				id = "FAILED: " + e.Error
+			}
+			t.AppendRow(table.Row{e.Path, id, safeBytes(&e.Size)})
+		}
+		t.Render()
+	}
+	if failed > 0 {
+		return &PartialSuccessError{Failed: failed, Total: len(manifest)}
+	}
+	return nil
+}
+
+// Returns all regular files below 'dir' and their total size
+func listDirFiles(dir string) (files []*manifestEntry, total int64, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &manifestEntry{Path: filepath.ToSlash(rel), Size: info.Size()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		err = newExitError(EXIT_USAGE, "while reading directory '%s' - %v", dir, err)
+	}
+	return
+}
+
+// Uploads the file of manifest entry 'e' below 'dir' and records its relative path as metadata
+func createDirArtifact(
+	ctxt context.Context,
+	dir string,
+	e *manifestEntry,
+	deployment string,
+	bar io.Writer,
+	adapter *a.Adapter,
+) (string, error) {
+	fileName := filepath.Join(dir, filepath.FromSlash(e.Path))
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	format := contentType
+	if format == "" {
+		if format, err = getFileContentType(file); err != nil {
+			return "", fmt.Errorf("while checking content type of file '%s' - %w", fileName, err)
+		}
+	}
+	digest, _ := getChecksumHash()
+	var reader io.Reader = bufio.NewReader(file)
+	if bar != nil {
+		reader = io.TeeReader(reader, bar)
+	}
+	req := &sdk.CreateArtifactRequest{
+		Name:       e.Path,
+		Size:       e.Size,
+		Collection: artifactCollection,
+	}
+	artifactID, resumed, err := createFileArtifact(ctxt, reader, fileName, format, e.Size, req,
+		deployment, digest, 1, true, adapter)
+	if err != nil {
+		return artifactID, err
+	}
+	if resumed {
+		// the upload may have been interrupted before the path got recorded
+		var recorded bool
+		if recorded, err = hasArtifactPath(ctxt, artifactID, adapter); err != nil || recorded {
+			return artifactID, err
+		}
+	}
+	if _, err = sdk.AddArtifactPath(ctxt, artifactID, e.Path, artifactCollection, adapter, logger); err != nil {
+		return artifactID, fmt.Errorf("while recording path of artifact '%s' - %w", artifactID, err)
+	}
+	return artifactID, nil
+}
+
+// Returns true if artifact 'artifactID' has a path recorded in its metadata
+func hasArtifactPath(ctxt context.Context, artifactID string, adapter *a.Adapter) (bool, error) {
+	artifact, err := sdk.ReadArtifact(ctxt, &sdk.ReadArtifactRequest{Id: artifactID}, adapter, logger)
+	if err != nil {
+		return false, fmt.Errorf("while getting metadata of artifact '%s' - %w", artifactID, err)
+	}
+	for _, m := range artifact.Metadata {
+		if m.Schema != nil && *m.Schema == sdk.ARTIFACT_PATH_SCHEMA {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Prints the details of an artifact after its content got uploaded
+func printUploadedArtifact(ctxt context.Context, artifactID string, adapter *a.Adapter) (err error) {
 	fmt.Printf("Completed uploading '%s'\n", artifactID)
 	readReq := &sdk.ReadArtifactRequest{Id: artifactID}
 
@@ -394,8 +754,7 @@ func upload(
 		if readResp, err = sdk.ReadArtifact(ctxt, readReq, adapter, logger); err == nil {
 			printArtifact(readResp, nil, false)
 		} else {
-			cobra.CompErrorln(fmt.Sprintf("while getting a status update on '%s' - %v", artifactID, err))
-			return
+			return fmt.Errorf("while getting a status update on '%s' - %w", artifactID, err)
 		}
 	}
 	return
@@ -404,42 +763,48 @@ func upload(
 func downloadArtifact(cmd *cobra.Command, args []string) error {
 	recordID := GetHistory(args[0])
 	req := &sdk.ReadArtifactRequest{Id: recordID}
-	adapter := CreateAdapter(true)
-	ctxt := context.Background()
+	adapter := CreateAdapterWithTimeout(true, DEF_TRANSFER_TIMEOUT)
+	ctxt, stats, err := startTransfer(cmd.Context())
+	if err != nil {
+		return err
+	}
 	artifact, err := sdk.ReadArtifact(ctxt, req, adapter, logger)
 	if err != nil {
 		return err
 	}
 	data := artifact.Data
 	if data == nil || data.Self == nil {
-		cobra.CheckErr("No data available")
+		return newExitError(EXIT_NOT_FOUND, "no data available for artifact '%s'", recordID)
 	}
 	url, err := url.ParseRequestURI(*data.Self)
 	if err != nil {
 		return err
 	}
 
+	if outputFile != "" && outputFile != "-" {
+		size := int64(-1)
+		if artifact.Size != nil {
+			size = *artifact.Size
+		}
+		if err = sdk.DownloadArtifactToFile(ctxt, recordID, url.Path, outputFile, size, parallelDownloads, adapter, silent, logger); err != nil {
+			return err
+		}
+		printTransferStats(stats)
+		return nil
+	}
+
 	downloadHandler := func(resp *http.Response, path string, logger *log.Logger) (err error) {
 		if resp.StatusCode >= 300 {
 			return a.ProcessErrorResponse(resp, path, nil, logger)
 		}
-
-		var outFile *os.File
-		if outputFile == "-" {
-			outFile = os.Stdout
-		} else {
-			outFile, err = os.Create(outputFile)
-			if err != nil {
-				return
-			}
-		}
 		var reader io.Reader
 		if silent {
 			reader = resp.Body
 		} else {
 			reader = sdk.AddProgressBar("... downloading file", resp.ContentLength, resp.Body)
 		}
-		_, err = io.Copy(outFile, reader)
+		reader = sdk.TransferReader(ctxt, reader)
+		_, err = io.Copy(os.Stdout, reader)
 		return
 	}
 
@@ -447,10 +812,105 @@ func downloadArtifact(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("\n") // To move past progress bar
+	if !silent {
+		fmt.Fprintf(os.Stderr, "\n") // To move past progress bar
+	}
+	printTransferStats(stats)
 	return nil
 }
 
+type deleteResult struct {
+	ID     string `json:"id" yaml:"id"`
+	Status string `json:"status" yaml:"status"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func deleteArtifacts(cmd *cobra.Command, args []string) error {
+	if !assumeYes {
+		for _, arg := range args {
+			if arg == "-" {
+				// stdin holds the IDs, so it can't answer the confirmation
+				return newExitError(EXIT_USAGE, "reading artifact IDs from stdin requires '--yes'")
+			}
+		}
+	}
+	ids, err := resolveArtifactIDs(args)
+	if err != nil {
+		return err
+	}
+	if !assumeYes {
+		question := fmt.Sprintf("Delete %d artifacts?", len(ids))
+		if len(ids) == 1 {
+			question = fmt.Sprintf("Delete artifact '%s'?", ids[0])
+		}
+		if !confirm(question) {
+			// declining isn't a failure, scripts should use '--yes' instead
+			if !silent {
+				fmt.Fprintln(os.Stderr, "Nothing deleted")
+			}
+			return nil
+		}
+	}
+	journal, err := loadUploadJournal()
+	if err != nil {
+		return err
+	}
+	deployment := GetActiveContext().URL
+	ctxt := cmd.Context()
+	var results []*deleteResult
+	err = forEachArtifactID(ids, func(artifactID string, adapter *a.Adapter) error {
+		res := &deleteResult{ID: artifactID, Status: "deleted"}
+		results = append(results, res)
+		e := journal.find(artifactID)
+		if e != nil && e.Deployment != deployment {
+			e = nil
+		}
+		if derr := deleteArtifact(ctxt, artifactID, e, adapter); derr != nil {
+			res.Status = "failed"
+			res.Error = derr.Error()
+			return derr
+		}
+		return nil
+	})
+	switch outputFormat {
+	case "json", "yaml":
+		if perr := printValue(results, outputFormat == "yaml"); perr != nil {
+			return perr
+		}
+	default:
+		if !silent {
+			t := table.NewWriter()
+			t.SetOutputMirror(os.Stdout)
+			t.AppendHeader(table.Row{"ID", "Result"})
+			for _, r := range results {
+				t.AppendRow(table.Row{r.ID, r.Status})
+			}
+			t.Render()
+		}
+	}
+	return err
+}
+
+// Deletes an artifact after terminating its unfinished upload 'e', if there is one
+func deleteArtifact(ctxt context.Context, artifactID string, e *uploadJournalEntry, adapter *a.Adapter) error {
+	logger.Debug("delete artifact", log.String("artifactID", artifactID))
+	if e != nil {
+		if err := terminateJournaledUpload(ctxt, e, adapter); err != nil {
+			return err
+		}
+	}
+	if _, err := sdk.DeleteArtifact(ctxt, artifactID, adapter, logger); err != nil {
+		return fmt.Errorf("while deleting artifact '%s' - %w", artifactID, err)
+	}
+	if e == nil {
+		return nil
+	}
+	return updateUploadJournal(func(j *uploadJournal) error {
+		j.remove(artifactID)
+		return nil
+	})
+}
+
 func printArtifactTable(list *api.ListResponseBody, wide bool) {
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
@@ -490,56 +950,90 @@ func printArtifact(artifact *api.ReadResponseBody, meta *meta.ListResponseBody,
 		{"Status", safeString(artifact.Status)},
 		{"Size", safeBytes(artifact.Size)},
 		{"Mime-type", safeString(artifact.MimeType)},
+		{"Collections", strings.Join(artifact.Collections, "\n")},
 		{"Account ID", safeString(artifact.Account.ID)},
 		{"Metadata", tw3.Render()},
 	})
 	fmt.Printf("\n%s\n\n", tw.Render())
 }
 
-func getReader(fileName string, proposedFormat string) (reader io.Reader, format string, size int64) {
+// Returns a usage error if the '--chunk-size' flag can't be used to split uploads,
+// or if a '--checksum' was asked for which can't be sent with unchunked uploads
+func checkChunkSize(cmd *cobra.Command) error {
+	if chunkSize == 0 {
+		return newExitError(EXIT_USAGE, "'--chunk-size' needs to be positive, or -1 for no chunking")
+	}
+	if chunkSize < 0 && checksum != sdk.CHECKSUM_NONE && cmd.Flags().Changed("checksum") {
+		return newExitError(EXIT_USAGE, "'--checksum %s' needs a positive '--chunk-size' to verify uploaded chunks", checksum)
+	}
+	return nil
+}
+
+// Returns the hash selected by the '--checksum' flag, or nil if content shouldn't be verified
+func getChecksumHash() (hash.Hash, error) {
+	if checksum == sdk.CHECKSUM_NONE {
+		return nil, nil
+	}
+	h, err := sdk.NewChecksumHash(checksum)
+	if err != nil {
+		return nil, &ExitError{EXIT_USAGE, err}
+	}
+	return h, nil
+}
+
+// Counts the bytes written through it to 'w'
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Returns a reader for 'fileName' ('-' for stdin), its content type unless given
+// as 'proposedFormat', and its size, which is -1 if not known
+func getReader(fileName string, proposedFormat string) (reader io.Reader, format string, size int64, err error) {
 	if fileName == "" {
-		cobra.CheckErr("Missing file name '-f'")
+		err = newExitError(EXIT_USAGE, "Missing file name '-f'")
+		return
 	}
 	format = proposedFormat
 	var file *os.File
-	var err error
 	size = -1 // -1 indicates that we can't obtain size
 	if fileName == "-" {
 		file = os.Stdin
 	} else {
 		if file, err = os.Open(fileName); err != nil {
-			cobra.CheckErr(fmt.Sprintf("while opening data file '%s' - %v", fileName, err))
+			err = newExitError(inputFileExitCode(err), "while opening data file '%s' - %v", fileName, err)
+			return
 		}
-		if info, err := file.Stat(); err == nil {
+		if info, serr := file.Stat(); serr == nil {
 			size = info.Size()
 		}
 		if proposedFormat == "" {
 			if format, err = getFileContentType(file); err != nil {
-				cobra.CheckErr(fmt.Sprintf("while checking content type of file '%s' - %v", fileName, err))
+				err = fmt.Errorf("while checking content type of file '%s' - %w", fileName, err)
+				return
 			}
 		}
 	}
+	br := bufio.NewReader(file)
 	if format == "" {
-		cobra.CheckErr("Missing content type [-t]")
-	}
-	reader = bufio.NewReader(file)
-	return
-}
-
-func getFileContentType(file *os.File) (contentType string, err error) {
-	buf := make([]byte, 512)
-	_, err = file.Read(buf)
-	if err != nil {
-		return
-	}
-	contentType = http.DetectContentType(buf)
-	if contentType == "application/octet-stream" {
-		// see if we can do better
-		n := file.Name()
-		if strings.HasSuffix(n, ".nc") {
-			contentType = "application/netcdf"
+		// content from stdin can only be detected by its first bytes
+		head, perr := br.Peek(CONTENT_SNIFF_LEN)
+		if perr != nil && perr != io.EOF && perr != bufio.ErrBufferFull {
+			err = fmt.Errorf("while reading data from stdin - %w", perr)
+			return
+		}
+		if len(head) == 0 {
+			err = newExitError(EXIT_USAGE, "Missing content type [-t]")
+			return
 		}
+		format = detectContentType("", head)
 	}
-	_, err = file.Seek(0, 0)
+	reader = br
 	return
 }
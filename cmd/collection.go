@@ -0,0 +1,219 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	sdk "github.com/reinventingscience/ivcap-cli/pkg"
+	a "github.com/reinventingscience/ivcap-cli/pkg/adapter"
+	api "github.com/reinventingscience/ivcap-core-api/http/artifact"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	log "go.uber.org/zap"
+)
+
+func init() {
+	artifactCmd.AddCommand(collectionCmd)
+	collectionCmd.AddCommand(addToCollectionCmd)
+	collectionCmd.AddCommand(removeFromCollectionCmd)
+	collectionCmd.AddCommand(listCollectionsCmd)
+	collectionCmd.AddCommand(lsCollectionCmd)
+}
+
+type artifactCollections struct {
+	ID          string   `json:"id" yaml:"id"`
+	Collections []string `json:"collections" yaml:"collections"`
+}
+
+var (
+	collectionCmd = &cobra.Command{
+		Use:     "collection",
+		Short:   "Manage the collections artifacts belong to",
+		Aliases: []string{"collections", "coll"},
+		Long: `Artifacts can be added to and removed from any number of collections.
+Commands accepting artifact IDs take any number of them, including history
+tokens (@n). Use '-' to read further IDs from stdin, one per line.`,
+	}
+
+	addToCollectionCmd = &cobra.Command{
+		Use:   "add collectionName artifactID [artifactID ...|-]",
+		Short: "Add artifacts to a collection",
+		Args:  cobra.MinimumNArgs(2),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			collectionName := args[0]
+			return forEachArtifact(args[1:], func(artifactID string, adapter *a.Adapter) error {
+				logger.Debug("add collection", log.String("artifactID", artifactID), log.String("collectionName", collectionName))
+				if _, err := sdk.AddArtifactToCollection(cmd.Context(), artifactID, collectionName, adapter, logger); err != nil {
+					return fmt.Errorf("while adding artifact '%s' to collection '%s' - %w", artifactID, collectionName, err)
+				}
+				return nil
+			})
+		},
+	}
+
+	removeFromCollectionCmd = &cobra.Command{
+		Use:     "remove collectionName artifactID [artifactID ...|-]",
+		Short:   "Remove artifacts from a collection",
+		Aliases: []string{"rm"},
+		Args:    cobra.MinimumNArgs(2),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			collectionName := args[0]
+			return forEachArtifact(args[1:], func(artifactID string, adapter *a.Adapter) error {
+				logger.Debug("rm collection", log.String("artifactID", artifactID), log.String("collectionName", collectionName))
+				if _, err := sdk.RemoveArtifactToCollection(cmd.Context(), artifactID, collectionName, adapter, logger); err != nil {
+					return fmt.Errorf("while removing artifact '%s' from collection '%s' - %w", artifactID, collectionName, err)
+				}
+				return nil
+			})
+		},
+	}
+
+	listCollectionsCmd = &cobra.Command{
+		Use:   "list artifactID [artifactID ...|-]",
+		Short: "List the collections of artifacts",
+		Args:  cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var result []*artifactCollections
+			err := forEachArtifact(args, func(artifactID string, adapter *a.Adapter) error {
+				artifact, err := sdk.ReadArtifact(cmd.Context(), &sdk.ReadArtifactRequest{Id: artifactID}, adapter, logger)
+				if err != nil {
+					return fmt.Errorf("while reading artifact '%s' - %w", artifactID, err)
+				}
+				result = append(result, &artifactCollections{ID: artifactID, Collections: artifact.Collections})
+				return nil
+			})
+			switch outputFormat {
+			case "json", "yaml":
+				if perr := printValue(result, outputFormat == "yaml"); perr != nil {
+					return perr
+				}
+			default:
+				t := table.NewWriter()
+				t.SetOutputMirror(os.Stdout)
+				t.AppendHeader(table.Row{"ID", "Collections"})
+				for _, r := range result {
+					t.AppendRow(table.Row{MakeHistory(&r.ID), strings.Join(r.Collections, "\n")})
+				}
+				t.Render()
+			}
+			return err
+		},
+	}
+
+	lsCollectionCmd = &cobra.Command{
+		Use:   "ls collectionName",
+		Short: "List all artifacts in a collection",
+		Long: `Lists all artifacts in a collection. As artifacts can't be listed by
+collection, this reads every artifact and may take a while.`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			list, err := listCollectionArtifacts(cmd.Context(), args[0], CreateAdapter(true))
+			if err != nil {
+				return err
+			}
+			switch outputFormat {
+			case "json", "yaml":
+				return printValue(list, outputFormat == "yaml")
+			default:
+				printArtifactTable(list, false)
+			}
+			return nil
+		},
+	}
+)
+
+// Returns the artifact IDs in 'args', which may be history tokens, or '-' to read
+// further IDs from stdin
+func resolveArtifactIDs(args []string) ([]string, error) {
+	var ids []string
+	for _, arg := range args {
+		if arg != "-" {
+			ids = append(ids, GetHistory(arg))
+			continue
+		}
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if id := strings.TrimSpace(scanner.Text()); id != "" {
+				ids = append(ids, GetHistory(id))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("while reading artifact IDs from stdin - %w", err)
+		}
+	}
+	return ids, nil
+}
+
+// Calls 'f' for every artifact ID in 'args' (see resolveArtifactIDs). Failures are
+// reported and counted, but don't stop the others.
+func forEachArtifact(args []string, f func(artifactID string, adapter *a.Adapter) error) error {
+	ids, err := resolveArtifactIDs(args)
+	if err != nil {
+		return err
+	}
+	return forEachArtifactID(ids, f)
+}
+
+func forEachArtifactID(ids []string, f func(artifactID string, adapter *a.Adapter) error) error {
+	adapter := CreateAdapter(true)
+	failed := 0
+	for _, id := range ids {
+		if err := f(id, adapter); err != nil {
+			if len(ids) == 1 {
+				return err
+			}
+			reportError(exitCodeFor(err), err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return &PartialSuccessError{Failed: failed, Total: len(ids)}
+	}
+	return nil
+}
+
+func listCollectionArtifacts(ctxt context.Context, collectionName string, adapter *a.Adapter) (*api.ListResponseBody, error) {
+	all, err := sdk.ListAllArtifacts(ctxt, adapter, logger)
+	if err != nil {
+		return nil, fmt.Errorf("while listing artifacts - %w", err)
+	}
+	list := &api.ListResponseBody{Artifacts: []*api.ArtifactListItemResponseBody{}}
+	for _, item := range all {
+		if item.ID == nil {
+			continue
+		}
+		artifact, err := sdk.ReadArtifact(ctxt, &sdk.ReadArtifactRequest{Id: *item.ID}, adapter, logger)
+		if err != nil {
+			return nil, fmt.Errorf("while reading artifact '%s' - %w", *item.ID, err)
+		}
+		for _, c := range artifact.Collections {
+			if c == collectionName {
+				list.Artifacts = append(list.Artifacts, item)
+				break
+			}
+		}
+	}
+	return list, nil
+}
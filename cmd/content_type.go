@@ -0,0 +1,229 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "go.uber.org/zap"
+)
+
+// Number of bytes at the start of a file used to detect its content type
+const CONTENT_SNIFF_LEN = 512
+
+// ContentTypeRule maps files to a content type by their name's extension, the bytes
+// ('magic') found at 'offset' of their content, or both if both are set.
+type ContentTypeRule struct {
+	ContentType string   `yaml:"content-type"`
+	Extensions  []string `yaml:"extensions,omitempty"` // e.g. '.csv' or '.zarr.zip'
+	Magic       string   `yaml:"magic,omitempty"`      // hex encoded, e.g. '89484446' for HDF5
+	Offset      int      `yaml:"offset,omitempty"`
+
+	magic []byte                 // decoded 'Magic'
+	check func(head []byte) bool // additional test for built-in rules
+}
+
+var builtinContentTypes = []ContentTypeRule{
+	{ContentType: "application/netcdf", Extensions: []string{".nc", ".nc4", ".cdf"}},
+	{ContentType: "application/geo+json", Extensions: []string{".geojson"}},
+	{ContentType: "text/csv", Extensions: []string{".csv"}},
+	{ContentType: "text/tab-separated-values", Extensions: []string{".tsv"}},
+	{ContentType: "application/vnd.zarr", Extensions: []string{".zarr.zip", ".zarr"}},
+	{ContentType: "application/json", Extensions: []string{".json", ".zarray", ".zgroup", ".zattrs", ".zmetadata"}},
+	{ContentType: "application/vnd.apache.parquet", magic: []byte("PAR1")},
+	{ContentType: "application/vnd.apache.parquet", Extensions: []string{".parquet"}},
+	{ContentType: "application/x-hdf5", magic: []byte("\x89HDF\r\n\x1a\n")},
+	{ContentType: "application/x-hdf5", Extensions: []string{".h5", ".hdf5", ".he5"}},
+	{ContentType: "application/netcdf", magic: []byte("CDF\x01")},
+	{ContentType: "application/netcdf", magic: []byte("CDF\x02")},
+	{ContentType: "image/tiff; application=geotiff", magic: []byte("II*\x00"), check: isGeoTIFF},
+	{ContentType: "image/tiff; application=geotiff", magic: []byte("MM\x00*"), check: isGeoTIFF},
+	{ContentType: "image/tiff", magic: []byte("II*\x00")},
+	{ContentType: "image/tiff", magic: []byte("MM\x00*")},
+}
+
+var (
+	contentTypeRules     []ContentTypeRule
+	contentTypeRulesOnce sync.Once
+)
+
+// Returns the rules of the 'content-types' section in the config file, followed by the built-in ones
+func getContentTypeRules() []ContentTypeRule {
+	contentTypeRulesOnce.Do(func() {
+		config, _ := ReadConfigFile(true)
+		for _, r := range config.ContentTypes {
+			if r.Magic != "" {
+				var err error
+				if r.magic, err = hex.DecodeString(r.Magic); err != nil {
+					logger.Warn("ignoring content type with invalid magic", log.String("content-type", r.ContentType), log.Error(err))
+					continue
+				}
+			}
+			contentTypeRules = append(contentTypeRules, r)
+		}
+		contentTypeRules = append(contentTypeRules, builtinContentTypes...)
+	})
+	return contentTypeRules
+}
+
+func (r *ContentTypeRule) matches(fileName string, head []byte) bool {
+	if r.Extensions == nil && r.magic == nil {
+		return false
+	}
+	if r.Extensions != nil {
+		name := strings.ToLower(fileName)
+		found := false
+		for _, ext := range r.Extensions {
+			if strings.HasSuffix(name, strings.ToLower(ext)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.magic != nil {
+		end := r.Offset + len(r.magic)
+		if r.Offset < 0 || len(head) < end || !bytes.Equal(head[r.Offset:end], r.magic) {
+			return false
+		}
+	}
+	return r.check == nil || r.check(head)
+}
+
+// Returns the content type of the file 'fileName' starting with 'head', using the
+// first matching rule, and 'http.DetectContentType' if none matches. 'fileName' may be
+// empty if not known.
+func detectContentType(fileName string, head []byte) string {
+	for _, r := range getContentTypeRules() {
+		if r.matches(fileName, head) {
+			return r.ContentType
+		}
+	}
+	return http.DetectContentType(head)
+}
+
+func getFileContentType(file *os.File) (contentType string, err error) {
+	buf := make([]byte, CONTENT_SNIFF_LEN)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	contentType = detectContentType(file.Name(), buf[:n])
+	_, err = file.Seek(0, 0)
+	return
+}
+
+type detectedContentType struct {
+	Path        string `json:"path" yaml:"path"`
+	ContentType string `json:"content-type" yaml:"content-type"`
+}
+
+// Prints the content type detected for 'fileName', or for all files in it with '--recursive'
+func printDetectedContentTypes(fileName string) error {
+	var result []*detectedContentType
+	if recursive {
+		files, _, err := listDirFiles(fileName)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			ct, err := detectFileContentType(filepath.Join(fileName, filepath.FromSlash(f.Path)))
+			if err != nil {
+				return err
+			}
+			result = append(result, &detectedContentType{Path: f.Path, ContentType: ct})
+		}
+	} else if fileName == "" || fileName == "-" {
+		_, ct, _, err := getReader(fileName, "")
+		if err != nil {
+			return err
+		}
+		result = append(result, &detectedContentType{Path: fileName, ContentType: ct})
+	} else {
+		ct, err := detectFileContentType(fileName)
+		if err != nil {
+			return err
+		}
+		result = append(result, &detectedContentType{Path: fileName, ContentType: ct})
+	}
+	switch {
+	case outputFormat == "json" || outputFormat == "yaml":
+		return printValue(result, outputFormat == "yaml")
+	case !recursive:
+		fmt.Println(result[0].ContentType)
+	default:
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Path", "Content-Type"})
+		for _, r := range result {
+			t.AppendRow(table.Row{r.Path, r.ContentType})
+		}
+		t.Render()
+	}
+	return nil
+}
+
+func detectFileContentType(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", newExitError(inputFileExitCode(err), "while opening data file '%s' - %v", fileName, err)
+	}
+	defer file.Close()
+	ct, err := getFileContentType(file)
+	if err != nil {
+		return "", fmt.Errorf("while checking content type of file '%s' - %w", fileName, err)
+	}
+	return ct, nil
+}
+
+// Returns true if the TIFF file starting with 'head' has a GeoKey directory tag in its
+// first IFD. Only IFDs found in 'head' are checked, which is where GDAL puts them.
+func isGeoTIFF(head []byte) bool {
+	const GEO_KEY_DIRECTORY_TAG = 34735
+	if len(head) < 8 {
+		return false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if head[0] == 'M' {
+		order = binary.BigEndian
+	}
+	offset := int(order.Uint32(head[4:8]))
+	if offset+2 > len(head) {
+		return false
+	}
+	count := int(order.Uint16(head[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+2 > len(head) {
+			return false
+		}
+		if order.Uint16(head[entry:entry+2]) == GEO_KEY_DIRECTORY_TAG {
+			return true
+		}
+	}
+	return false
+}
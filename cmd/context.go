@@ -18,10 +18,13 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
+
+	adpt "github.com/reinventingscience/ivcap-cli/pkg/adapter"
 )
 
 func init() {
@@ -37,6 +40,12 @@ func init() {
 	// createContextCmd.Flags().StringVar(&providerID, "provider-id", "", "The account ID to use. Will most likely be set on login")
 	createContextCmd.Flags().StringVar(&hostName, "host-name", "", "optional host name if accessing API through SSH tunnel")
 	createContextCmd.Flags().IntVar(&ctxtApiVersion, "version", 1, "define API version")
+	createContextCmd.Flags().StringVar(&caFile, "ca-file", "", "PEM file with additional CA certificates to trust")
+	createContextCmd.Flags().StringVar(&clientCert, "client-cert", "", "PEM file with client certificate for mutual TLS")
+	createContextCmd.Flags().StringVar(&clientKey, "client-key", "", "PEM file with key for client certificate")
+	createContextCmd.Flags().StringVar(&proxyURL, "proxy", "", "URL of HTTP proxy to use [HTTPS_PROXY]")
+	createContextCmd.Flags().BoolVar(&insecure, "insecure", false, "do not verify server certificate - ONLY USE FOR DEVELOPMENT")
+	createContextCmd.Flags().BoolVar(&disableHTTP2, "disable-http2", false, "only use HTTP/1.1")
 
 	// SET/USE
 	configCmd.AddCommand(useContextCmd)
@@ -51,6 +60,12 @@ var (
 	ctxtApiVersion int
 	hostName       string
 	refreshToken   bool
+	caFile         string
+	clientCert     string
+	clientKey      string
+	proxyURL       string
+	insecure       bool
+	disableHTTP2   bool
 )
 
 // configCmd represents the config command
@@ -70,14 +85,25 @@ var createContextCmd = &cobra.Command{
 		ctxtUrl := args[1]
 		url, err := url.ParseRequestURI(ctxtUrl)
 		if err != nil || url.Host == "" {
-			cobra.CheckErr(fmt.Sprintf("url '%s' is not a valid URL", ctxtUrl))
+			checkErr(EXIT_USAGE, fmt.Sprintf("url '%s' is not a valid URL", ctxtUrl))
 		}
 
 		ctxt := &Context{
-			ApiVersion: ctxtApiVersion,
-			Name:       ctxtName,
-			URL:        ctxtUrl,
-			Host:       hostName,
+			ApiVersion:   ctxtApiVersion,
+			Name:         ctxtName,
+			URL:          ctxtUrl,
+			Host:         hostName,
+			CAFile:       absPath(caFile),
+			ClientCert:   absPath(clientCert),
+			ClientKey:    absPath(clientKey),
+			Proxy:        proxyURL,
+			Insecure:     insecure,
+			DisableHTTP2: disableHTTP2,
+		}
+		// fail early if any of the above is misconfigured. Don't use the shared
+		// transport as it belongs to the active context.
+		if _, err := adpt.NewTransport(transportConfig(ctxt)); err != nil {
+			checkErr(EXIT_USAGE, fmt.Sprintf("invalid connection settings - %s", err))
 		}
 		SetContext(ctxt, false)
 		fmt.Printf("Context '%s' created.\n", ctxtName)
@@ -113,7 +139,7 @@ var useContextCmd = &cobra.Command{
 	Aliases: []string{"use"},
 	Run: func(_ *cobra.Command, args []string) {
 		if len(args) < 1 {
-			cobra.CheckErr("Missing 'name' arg")
+			checkErr(EXIT_USAGE, "Missing 'name' arg")
 		}
 		ctxtName = args[0]
 		config, _ := ReadConfigFile(false)
@@ -129,7 +155,7 @@ var useContextCmd = &cobra.Command{
 			WriteConfigFile(config)
 			fmt.Printf("Switched to context '%s'.\n", ctxtName)
 		} else {
-			cobra.CheckErr(fmt.Sprintf("context '%s' is not defined", ctxtName))
+			checkErr(EXIT_USAGE, fmt.Sprintf("context '%s' is not defined", ctxtName))
 		}
 	},
 }
@@ -183,10 +209,36 @@ var getContextCmd = &cobra.Command{
 			if context.Host != "" {
 				t.AppendRow(table.Row{"Host", context.Host})
 			}
+			if context.CAFile != "" {
+				t.AppendRow(table.Row{"CA File", context.CAFile})
+			}
+			if context.ClientCert != "" {
+				t.AppendRow(table.Row{"Client Cert", context.ClientCert})
+			}
+			if context.Proxy != "" {
+				t.AppendRow(table.Row{"Proxy", context.Proxy})
+			}
+			if context.Insecure {
+				t.AppendRow(table.Row{"Insecure", "yes, server certificate is NOT verified"})
+			}
+			if context.DisableHTTP2 {
+				t.AppendRow(table.Row{"HTTP/2", "disabled"})
+			}
 
 			t.Render()
 		} else {
-			cobra.CheckErr(fmt.Sprintf("unknown context parameter '%s'", param))
+			checkErr(EXIT_USAGE, fmt.Sprintf("unknown context parameter '%s'", param))
 		}
 	},
 }
+
+// Files referenced in a context need to be found from any working directory
+func absPath(fileName string) string {
+	if fileName == "" {
+		return ""
+	}
+	if p, err := filepath.Abs(fileName); err == nil {
+		return p
+	}
+	return fileName
+}
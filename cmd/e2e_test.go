@@ -0,0 +1,1004 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/reinventingscience/ivcap-cli/pkg"
+	"github.com/reinventingscience/ivcap-cli/pkg/testserver"
+)
+
+// If set, the test binary runs the CLI with the JSON encoded arguments
+// instead of the tests. This gives every command a fresh process, just
+// like when invoked from a shell.
+const E2E_ARGS_ENV = "IVCAP_E2E_ARGS"
+
+const E2E_ACCESS_TOKEN = "test-token"
+
+func TestMain(m *testing.M) {
+	if args := os.Getenv(E2E_ARGS_ENV); args != "" {
+		var a []string
+		if err := json.Unmarshal([]byte(args), &a); err != nil {
+			panic(err)
+		}
+		rootCmd.SetArgs(a)
+		Execute("test")
+		os.Exit(EXIT_SUCCESS)
+	}
+	os.Exit(m.Run())
+}
+
+type e2eEnv struct {
+	t         *testing.T
+	srv       *testserver.Server
+	configDir string
+	workDir   string
+}
+
+// Starts a fake deployment and creates a context for it in a private config directory
+func newE2E(t *testing.T) *e2eEnv {
+	srv := testserver.New()
+	srv.AccessToken = E2E_ACCESS_TOKEN
+	t.Cleanup(srv.Close)
+
+	e := &e2eEnv{t: t, srv: srv, configDir: t.TempDir(), workDir: t.TempDir()}
+	// avoid checking github for a newer version
+	dir := filepath.Join(e.configDir, CONFIG_FILE_DIR)
+	os.MkdirAll(dir, 0755)
+	ts := time.Now().Format(time.RFC3339)
+	ioutil.WriteFile(filepath.Join(dir, VERSION_CHECK_FILE_NAME), []byte(ts), 0600)
+
+	e.mustRun("context", "create", "test", srv.URL)
+	return e
+}
+
+// Runs the CLI with 'args' and returns what it wrote to stdout and stderr, and its exit code
+func (e *e2eEnv) run(args ...string) (stdout string, stderr string, code int) {
+	return e.runWithInput("", args...)
+}
+
+// Returns a process which runs the CLI with 'args'
+func (e *e2eEnv) command(args ...string) *exec.Cmd {
+	a, _ := json.Marshal(args)
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Dir = e.workDir
+	cmd.Env = append(os.Environ(),
+		E2E_ARGS_ENV+"="+string(a),
+		"XDG_CONFIG_HOME="+e.configDir,
+		"HOME="+e.configDir,
+		ACCESS_TOKEN_ENV+"="+E2E_ACCESS_TOKEN,
+	)
+	return cmd
+}
+
+// Runs the CLI with 'args', feeding it 'input' on stdin
+func (e *e2eEnv) runWithInput(input string, args ...string) (stdout string, stderr string, code int) {
+	cmd := e.command(args...)
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		e.t.Fatalf("cannot run CLI - %v", err)
+	}
+	return outb.String(), errb.String(), code
+}
+
+// Runs the CLI with 'args', which need to start a slow upload, and interrupts it once
+// the server received some of the content. Returns the journal entry of that upload.
+func (e *e2eEnv) interruptUpload(args ...string) *uploadJournalEntry {
+	cmd := e.command(args...)
+	if err := cmd.Start(); err != nil {
+		e.t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	var journal uploadJournal
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			e.t.Fatalf("Expected 'ivcap %s' to make progress", strings.Join(args, " "))
+		}
+		journal.Uploads = nil
+		e.mustRunJSON(&journal, "artifact", "uploads")
+		if len(journal.Uploads) == 1 {
+			if data, _ := e.srv.Artifact(journal.Uploads[0].ArtifactID); len(data) >= 2000 {
+				break
+			}
+		}
+	}
+	cmd.Process.Signal(os.Interrupt)
+	if err := cmd.Wait(); err == nil {
+		e.t.Fatalf("Expected interrupted 'ivcap %s' to fail", strings.Join(args, " "))
+	}
+	return journal.Uploads[0]
+}
+
+func (e *e2eEnv) mustRun(args ...string) string {
+	stdout, stderr, code := e.run(args...)
+	if code != EXIT_SUCCESS {
+		e.t.Fatalf("'ivcap %s' failed with exit code %d: %s", strings.Join(args, " "), code, stderr)
+	}
+	return stdout
+}
+
+// Runs the CLI with 'args' and the '-o json' flag and parses its output into 'result'
+func (e *e2eEnv) mustRunJSON(result interface{}, args ...string) {
+	out := e.mustRun(append(args, "-o", "json")...)
+	if err := json.Unmarshal([]byte(out), result); err != nil {
+		e.t.Fatalf("cannot parse output of 'ivcap %s' - %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func (e *e2eEnv) writeFile(name string, content string) string {
+	path := filepath.Join(e.workDir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		e.t.Fatal(err)
+	}
+	return path
+}
+
+func (e *e2eEnv) expectExitCode(expected int, args ...string) {
+	_, stderr, code := e.run(args...)
+	if code != expected {
+		e.t.Fatalf("Expected 'ivcap %s' to exit with %d, but got %d: %s", strings.Join(args, " "), expected, code, stderr)
+	}
+}
+
+const testServiceYAML = `
+name: hello
+description: Says hello
+provider-id: urn:ivcap:provider:00000000-0000-0000-0000-000000000000
+account-id: urn:ivcap:account:00000000-0000-0000-0000-000000000000
+workflow:
+  type: basic
+  basic:
+    image: hello:latest
+parameters:
+  - name: msg
+    type: string
+  - name: times
+    type: int
+    default: "1"
+`
+
+func TestE2EServiceAndOrder(t *testing.T) {
+	e := newE2E(t)
+	svcFile := e.writeFile("service.yaml", testServiceYAML)
+
+	var svc struct{ ID string }
+	e.mustRunJSON(&svc, "service", "create", "-f", svcFile)
+	if svc.ID == "" {
+		t.Fatalf("Expected service ID")
+	}
+	var svcList struct{ Services []struct{ ID string } }
+	e.mustRunJSON(&svcList, "service", "list")
+	if len(svcList.Services) != 1 || svcList.Services[0].ID != svc.ID {
+		t.Fatalf("Unexpected service list %+v", svcList)
+	}
+
+	var order struct {
+		ID         string
+		Status     string
+		Parameters []struct{ Name, Value string }
+	}
+	e.mustRunJSON(&order, "order", "create", svc.ID, "msg=Hello World")
+	if order.Status != testserver.ORDER_PENDING || len(order.Parameters) != 2 {
+		t.Fatalf("Unexpected order %+v", order)
+	}
+	var order2 struct{ ID, Status string }
+	e.mustRunJSON(&order2, "order", "get", order.ID)
+	if order2.ID != order.ID {
+		t.Fatalf("Expected order '%s', but got '%s'", order.ID, order2.ID)
+	}
+	if out := e.mustRun("order", "list"); !strings.Contains(out, "hello") {
+		t.Fatalf("Expected order list to show service name, but got\n%s", out)
+	}
+
+	e.expectExitCode(EXIT_USAGE, "order", "create", svc.ID, "foo=bar")
+	e.expectExitCode(EXIT_NOT_FOUND, "order", "get", "urn:ivcap:order:unknown")
+}
+
+func TestE2EOrderWait(t *testing.T) {
+	e := newE2E(t)
+	svcFile := e.writeFile("service.yaml", testServiceYAML)
+	var svc struct{ ID string }
+	e.mustRunJSON(&svc, "service", "create", "-f", svcFile)
+
+	var order struct{ ID, Status string }
+	e.mustRunJSON(&order, "order", "create", svc.ID, "msg=Hello World")
+	e.expectExitCode(EXIT_TIMEOUT, "order", "wait", "--wait-timeout", "1", order.ID)
+	e.expectExitCode(EXIT_TIMEOUT, "order", "create", "--wait", "--wait-timeout", "1", svc.ID, "msg=Hello World")
+	e.expectExitCode(EXIT_USAGE, "order", "wait", "--wait-timeout", "-1", order.ID)
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		e.srv.SetOrderStatus(order.ID, testserver.ORDER_SUCCEEDED)
+	}()
+	var done struct{ ID, Status string }
+	e.mustRunJSON(&done, "order", "wait", order.ID)
+	if done.ID != order.ID || done.Status != testserver.ORDER_SUCCEEDED {
+		t.Fatalf("Unexpected order %+v", done)
+	}
+
+	var failed struct{ ID string }
+	e.mustRunJSON(&failed, "order", "create", svc.ID, "msg=Hello World")
+	e.srv.SetOrderStatus(failed.ID, testserver.ORDER_FAILED)
+	e.expectExitCode(EXIT_ERROR, "order", "wait", failed.ID)
+	if out := e.mustRun("order", "wait", order.ID); !strings.Contains(out, "status 'succeeded'") {
+		t.Fatalf("Expected wait to report final status, but got\n%s", out)
+	}
+}
+
+func TestE2EOrderParameterFile(t *testing.T) {
+	e := newE2E(t)
+	svcFile := e.writeFile("service.yaml", testServiceYAML)
+	var svc struct{ ID string }
+	e.mustRunJSON(&svc, "service", "create", "-f", svcFile)
+
+	template := e.mustRun("order", "create", "--template", svc.ID, "msg=Hi")
+	if !strings.Contains(template, "msg: \"Hi\"") || !strings.Contains(template, "times: \"1\"") {
+		t.Fatalf("Unexpected parameter template\n%s", template)
+	}
+	paramFile := e.writeFile("params.yaml", strings.Replace(template, "times: \"1\"", "times: 3", 1))
+
+	var order struct {
+		Parameters []struct{ Name, Value string }
+	}
+	e.mustRunJSON(&order, "order", "create", "-f", paramFile, svc.ID, "msg=Hello")
+	values := map[string]string{}
+	for _, p := range order.Parameters {
+		values[p.Name] = p.Value
+	}
+	if values["msg"] != "Hello" || values["times"] != "3" {
+		t.Fatalf("Expected parameters from file and command line, but got %+v", order.Parameters)
+	}
+
+	badFile := e.writeFile("bad.yaml", "foo: bar\n")
+	e.expectExitCode(EXIT_USAGE, "order", "create", "-f", badFile, svc.ID)
+	e.expectExitCode(EXIT_NOT_FOUND, "order", "create", "-f", filepath.Join(e.workDir, "missing.yaml"), svc.ID)
+}
+
+const testTypedServiceYAML = `
+name: typed
+description: Has typed parameters
+provider-id: urn:ivcap:provider:00000000-0000-0000-0000-000000000000
+account-id: urn:ivcap:account:00000000-0000-0000-0000-000000000000
+workflow:
+  type: basic
+  basic:
+    image: typed:latest
+parameters:
+  - name: threshold
+    type: number
+  - name: freq
+    type: option
+    default: MS
+    options:
+      - value: MS
+      - value: YS
+  - name: verbose
+    type: bool
+    optional: true
+  - name: input
+    type: artifact
+    optional: true
+  - name: version
+    type: string
+    constant: true
+    default: "1.0"
+`
+
+func TestE2EOrderParameterValidation(t *testing.T) {
+	e := newE2E(t)
+	svcFile := e.writeFile("typed.yaml", testTypedServiceYAML)
+	var svc struct{ ID string }
+	e.mustRunJSON(&svc, "service", "create", "-f", svcFile)
+
+	var order struct {
+		Parameters []struct{ Name, Value string }
+	}
+	e.mustRunJSON(&order, "order", "create", svc.ID, "threshold=10.8")
+	if len(order.Parameters) != 3 {
+		t.Fatalf("Expected defaults to be filled in, but got %+v", order.Parameters)
+	}
+
+	_, stderr, code := e.run("order", "create", svc.ID, "freq=DAY", "verbose=maybe", "input=data.csv", "version=2")
+	if code != EXIT_USAGE {
+		t.Fatalf("Expected exit code %d, but got %d: %s", EXIT_USAGE, code, stderr)
+	}
+	for _, problem := range []string{"'freq' needs to be one of 'MS', 'YS'", "'verbose' needs to be 'true' or 'false'",
+		"'input' needs to be an artifact", "'version' is constant", "'threshold' is required"} {
+		if !strings.Contains(stderr, problem) {
+			t.Fatalf("Expected '%s' to be reported, but got\n%s", problem, stderr)
+		}
+	}
+	e.expectExitCode(EXIT_USAGE, "order", "create", svc.ID, "threshold=high")
+}
+
+func TestE2EOrderFileParameter(t *testing.T) {
+	e := newE2E(t)
+	svcFile := e.writeFile("typed.yaml", testTypedServiceYAML)
+	var svc struct{ ID string }
+	e.mustRunJSON(&svc, "service", "create", "-f", svcFile)
+	e.writeFile("input.csv", "a,b\n1,2\n")
+
+	inputOf := func(args ...string) string {
+		var order struct {
+			Parameters []struct{ Name, Value string }
+		}
+		e.mustRunJSON(&order, append([]string{"order", "create", svc.ID, "threshold=1"}, args...)...)
+		for _, p := range order.Parameters {
+			if p.Name == "input" {
+				return p.Value
+			}
+		}
+		return ""
+	}
+	first := inputOf("input=@file:input.csv")
+	if !strings.HasPrefix(first, "urn:ivcap:artifact:") {
+		t.Fatalf("Expected file to be replaced by artifact URN, but got '%s'", first)
+	}
+	var artifact struct{ Name, Status string }
+	e.mustRunJSON(&artifact, "artifact", "get", first)
+	if artifact.Name != "input.csv" || artifact.Status != testserver.ARTIFACT_AVAILABLE {
+		t.Fatalf("Unexpected uploaded artifact %+v", artifact)
+	}
+	if second := inputOf("input=input.csv"); second == first || !strings.HasPrefix(second, "urn:ivcap:artifact:") {
+		t.Fatalf("Expected local path to be uploaded as new artifact, but got '%s'", second)
+	}
+	chunked := inputOf("input=@file:input.csv", "--chunk-size", "3", "--checksum", "md5")
+	if data, ok := e.srv.Artifact(chunked); !ok || string(data) != "a,b\n1,2\n" {
+		t.Fatalf("Unexpected content of file uploaded in chunks '%s'", data)
+	}
+	e.expectExitCode(EXIT_USAGE, "order", "create", svc.ID, "threshold=1", "input=@file:input.csv", "--chunk-size", "0")
+	e.expectExitCode(EXIT_USAGE, "order", "create", svc.ID, "threshold=1", "input=@file:missing.csv")
+}
+
+const testNumericOptionsServiceYAML = `
+name: numeric
+description: Has numeric options
+provider-id: urn:ivcap:provider:00000000-0000-0000-0000-000000000000
+account-id: urn:ivcap:account:00000000-0000-0000-0000-000000000000
+workflow:
+  type: basic
+  basic:
+    image: numeric:latest
+parameters:
+  - name: first
+    type: option
+    options:
+      - value: "5"
+      - value: "1"
+      - value: "10"
+  - name: second
+    type: option
+    options:
+      - value: "5"
+      - value: "1"
+      - value: "10"
+`
+
+func TestE2EInteractiveNumericOptions(t *testing.T) {
+	e := newE2E(t)
+	svcFile := e.writeFile("numeric.yaml", testNumericOptionsServiceYAML)
+	var svc struct{ ID string }
+	e.mustRunJSON(&svc, "service", "create", "-f", svcFile)
+
+	// first: '1' is an option's value; second: '3' only matches the 3rd option's number
+	stdout, stderr, code := e.runWithInput("1\n3\n\ny\n", "order", "create", "-i", svc.ID, "-o", "json")
+	if code != EXIT_SUCCESS {
+		t.Fatalf("Interactive order failed with exit code %d: %s", code, stderr)
+	}
+	var order struct {
+		Parameters []struct{ Name, Value string }
+	}
+	if err := json.Unmarshal([]byte(stdout), &order); err != nil {
+		t.Fatalf("cannot parse order - %v\n%s", err, stdout)
+	}
+	values := map[string]string{}
+	for _, p := range order.Parameters {
+		values[p.Name] = p.Value
+	}
+	if values["first"] != "1" || values["second"] != "10" {
+		t.Fatalf("Unexpected parameters %+v", order.Parameters)
+	}
+}
+
+func TestE2EInteractiveOrder(t *testing.T) {
+	e := newE2E(t)
+	svcFile := e.writeFile("typed.yaml", testTypedServiceYAML)
+	var svc struct{ ID string }
+	e.mustRunJSON(&svc, "service", "create", "-f", svcFile)
+
+	// threshold: required, first answer invalid; freq: pick 2nd option; verbose: default;
+	// input: skip; save the parameters and submit
+	input := "\nhigh\n10.8\n2\n\n\nparams.yaml\ny\n"
+	stdout, stderr, code := e.runWithInput(input, "order", "create", "-i", svc.ID, "verbose=true", "-o", "json")
+	if code != EXIT_SUCCESS {
+		t.Fatalf("Interactive order failed with exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "'threshold' is required") || !strings.Contains(stderr, "needs to be a number") {
+		t.Fatalf("Expected invalid answers to be reported, but got\n%s", stderr)
+	}
+	var order struct {
+		Parameters []struct{ Name, Value string }
+	}
+	if err := json.Unmarshal([]byte(stdout), &order); err != nil {
+		t.Fatalf("cannot parse order - %v\n%s", err, stdout)
+	}
+	values := map[string]string{}
+	for _, p := range order.Parameters {
+		values[p.Name] = p.Value
+	}
+	if values["threshold"] != "10.8" || values["freq"] != "YS" || values["verbose"] != "true" || values["input"] != "" {
+		t.Fatalf("Unexpected parameters %+v", order.Parameters)
+	}
+
+	// submit again from the saved file, but cancel
+	_, _, code = e.runWithInput("\n\n\n\n\nn\n", "order", "create", "-i", "-f", "params.yaml", svc.ID)
+	if code != EXIT_CANCELLED {
+		t.Fatalf("Expected exit code %d, but got %d", EXIT_CANCELLED, code)
+	}
+}
+
+func TestE2EArtifactUpload(t *testing.T) {
+	e := newE2E(t)
+	content := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(content)
+	file := e.writeFile("data.bin", string(content))
+
+	out := e.mustRun("artifact", "create", "-n", "data", "-f", file, "-t", "application/octet-stream",
+		"--chunk-size", "3000", "--silent")
+	id := strings.TrimSpace(out)
+	if data, ok := e.srv.Artifact(id); !ok || !bytes.Equal(data, content) {
+		t.Fatalf("Expected server to hold uploaded content of artifact '%s'", id)
+	}
+	e.expectExitCode(EXIT_USAGE, "artifact", "create", "-f", file, "-t", "application/octet-stream", "--chunk-size", "0")
+	e.expectExitCode(EXIT_USAGE, "artifact", "upload", id, "-f", file, "--chunk-size", "0")
+	e.expectExitCode(EXIT_USAGE, "artifact", "create", "-f", file, "-t", "application/octet-stream",
+		"--chunk-size", "-1", "--checksum", sdk.CHECKSUM_MD5)
+
+	// chunks rejected with a checksum mismatch are sent again
+	e.srv.CorruptChunks(2)
+	out = e.mustRun("artifact", "create", "-n", "parallel", "-f", file, "-t", "application/octet-stream",
+		"--chunk-size", "1000", "--parallel", "3", "--silent")
+	pid := strings.TrimSpace(out)
+	if data, ok := e.srv.Artifact(pid); !ok || !bytes.Equal(data, content) {
+		t.Fatalf("Expected server to hold content of parallel uploaded artifact '%s'", pid)
+	}
+
+	// without the tus 'concatenation' extension, files are uploaded sequentially
+	e.srv.DisableConcatenation()
+	out = e.mustRun("artifact", "create", "-n", "sequential", "-f", file, "-t", "application/octet-stream",
+		"--chunk-size", "1000", "--parallel", "3", "--silent")
+	sid := strings.TrimSpace(out)
+	if data, ok := e.srv.Artifact(sid); !ok || !bytes.Equal(data, content) {
+		t.Fatalf("Expected server to hold content of sequentially uploaded artifact '%s'", sid)
+	}
+
+	var artifact struct{ ID, Name, Status string }
+	e.mustRunJSON(&artifact, "artifact", "get", id)
+	if artifact.Name != "data" || artifact.Status != testserver.ARTIFACT_AVAILABLE {
+		t.Fatalf("Unexpected artifact %+v", artifact)
+	}
+
+	outFile := filepath.Join(e.workDir, "out.bin")
+	e.mustRun("artifact", "download", id, "-f", outFile, "--silent")
+	if data, err := ioutil.ReadFile(outFile); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Expected downloaded content to match upload")
+	}
+
+	// continues from content downloaded earlier
+	ioutil.WriteFile(outFile+".part", content[:4000], 0644)
+	ioutil.WriteFile(outFile+".part.info", []byte(fmt.Sprintf(`{"artifact-id": "%s"}`, id)), 0644)
+	e.mustRun("artifact", "download", id, "-f", outFile, "--silent")
+	if data, err := ioutil.ReadFile(outFile); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Expected resumed download to match upload")
+	}
+	for _, suffix := range []string{".part", ".part.info"} {
+		if _, err := os.Stat(outFile + suffix); err == nil {
+			t.Fatalf("Expected '%s' of partial download to be removed", suffix)
+		}
+	}
+	// starts again if what was downloaded earlier isn't known to be from the same content
+	for _, info := range []string{
+		"",
+		fmt.Sprintf(`{"artifact-id": "%s"}`, pid),
+		fmt.Sprintf(`{"artifact-id": "%s", "etag": "\"changed\""}`, id),
+	} {
+		ioutil.WriteFile(outFile+".part", bytes.Repeat([]byte{'x'}, 4000), 0644)
+		if info != "" {
+			ioutil.WriteFile(outFile+".part.info", []byte(info), 0644)
+		}
+		e.mustRun("artifact", "download", id, "-f", outFile, "--silent")
+		if data, err := ioutil.ReadFile(outFile); err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("Expected download to start again with info '%s'", info)
+		}
+	}
+	// the request timeout doesn't cut off content which takes longer to arrive
+	e.srv.SlowDownloads(1500 * time.Millisecond)
+	e.mustRun("artifact", "download", id, "-f", outFile, "--timeout", "1", "--silent")
+	if data, err := ioutil.ReadFile(outFile); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Expected slow download to match upload")
+	}
+	e.srv.SlowDownloads(0)
+	rangesFile := filepath.Join(e.workDir, "ranges.bin")
+	e.mustRun("artifact", "download", id, "-f", rangesFile, "--parallel", "4", "--silent")
+	if data, err := ioutil.ReadFile(rangesFile); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("Expected download in parallel ranges to match upload")
+	}
+
+	metaFile := e.writeFile("meta.json", `{"$schema": "urn:test:schema", "foo": 1}`)
+	e.mustRun("artifact", "add-metadata", id, "urn:test:schema", "-f", metaFile)
+	var list struct {
+		Records []struct {
+			Entity, Schema string
+			Aspect         sdk.ArtifactChecksum
+		}
+	}
+	e.mustRunJSON(&list, "metadata", "query", "-e", id, "-s", "urn:test:schema")
+	if len(list.Records) != 1 || list.Records[0].Schema != "urn:test:schema" {
+		t.Fatalf("Unexpected metadata %+v", list)
+	}
+	list.Records = nil
+	e.mustRunJSON(&list, "metadata", "query", "-e", id, "-s", sdk.ARTIFACT_CHECKSUM_SCHEMA)
+	digest := sha256.Sum256(content)
+	if len(list.Records) != 1 || list.Records[0].Aspect.Digest != hex.EncodeToString(digest[:]) {
+		t.Fatalf("Expected sha256 checksum of content, but got %+v", list)
+	}
+
+	e.mustRun("artifact", "remove-metadata", id, "urn:test:schema")
+	list.Records = nil
+	e.mustRunJSON(&list, "metadata", "query", "-e", id, "-s", "urn:test:schema")
+	if len(list.Records) != 0 {
+		t.Fatalf("Expected metadata to be removed, but got %+v", list)
+	}
+	e.expectExitCode(EXIT_NOT_FOUND, "artifact", "remove-metadata", id, "urn:test:schema")
+}
+
+func TestE2ECollections(t *testing.T) {
+	e := newE2E(t)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		file := e.writeFile(fmt.Sprintf("f%d.txt", i), "content")
+		out := e.mustRun("artifact", "create", "-f", file, "-t", "text/plain", "--silent")
+		ids = append(ids, strings.TrimSpace(out))
+	}
+	collection := "urn:test:collection"
+	e.mustRun(append([]string{"artifact", "collection", "add", collection}, ids...)...)
+
+	var list struct{ Artifacts []struct{ ID string } }
+	e.mustRunJSON(&list, "artifact", "collection", "ls", collection)
+	if len(list.Artifacts) != 3 {
+		t.Fatalf("Expected 3 artifacts in collection, but got %+v", list)
+	}
+
+	e.expectExitCode(EXIT_PARTIAL, "artifact", "collection", "rm", collection, ids[0], "urn:ivcap:artifact:unknown")
+	var colls []artifactCollections
+	e.mustRunJSON(&colls, "artifact", "collection", "list", ids[0], ids[1])
+	if len(colls) != 2 || len(colls[0].Collections) != 0 || len(colls[1].Collections) != 1 {
+		t.Fatalf("Expected only first artifact to be removed from collection, but got %+v", colls)
+	}
+	list.Artifacts = nil
+	e.mustRunJSON(&list, "artifact", "collection", "ls", collection)
+	if len(list.Artifacts) != 2 {
+		t.Fatalf("Expected 2 artifacts left in collection, but got %+v", list)
+	}
+}
+
+func TestE2ELimitRate(t *testing.T) {
+	e := newE2E(t)
+	content := strings.Repeat("x", 100000)
+	file := e.writeFile("data.bin", content)
+
+	start := time.Now()
+	_, stderr, code := e.run("artifact", "create", "-f", file, "-t", "application/octet-stream",
+		"--limit-rate", "200KB/s", "--silent", "-o", "json")
+	if code != EXIT_SUCCESS {
+		t.Fatalf("Expected upload to succeed, but got %d: %s", code, stderr)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("Expected upload of 100KB at 200KB/s to take about 0.5s, but took %v", elapsed)
+	}
+	var summary transferSummary
+	if err := json.Unmarshal([]byte(stderr), &summary); err != nil || summary.Bytes != int64(len(content)) {
+		t.Fatalf("Expected transfer summary on stderr, but got %s", stderr)
+	}
+	if summary.BytesPerSec > 250000 {
+		t.Fatalf("Expected throughput below limit, but got %+v", summary)
+	}
+
+	// chunks sent again after a checksum mismatch are counted as well
+	e.srv.CorruptChunks(2)
+	_, stderr, code = e.run("artifact", "create", "-f", file, "-t", "application/octet-stream",
+		"--chunk-size", "10000", "--limit-rate", "1MB/s", "--silent", "-o", "json")
+	if code != EXIT_SUCCESS {
+		t.Fatalf("Expected upload to succeed, but got %d: %s", code, stderr)
+	}
+	summary = transferSummary{}
+	if err := json.Unmarshal([]byte(stderr), &summary); err != nil || summary.Bytes != int64(len(content))+20000 || summary.Retries != 2 {
+		t.Fatalf("Expected two chunks to be counted again, but got %s", stderr)
+	}
+	e.expectExitCode(EXIT_USAGE, "artifact", "create", "-f", file, "--limit-rate", "fast")
+}
+
+func TestE2EResumeUpload(t *testing.T) {
+	e := newE2E(t)
+	content := make([]byte, 10000)
+	rand.New(rand.NewSource(2)).Read(content)
+	file := e.writeFile("data.bin", string(content))
+	args := []string{"artifact", "create", "-f", file, "-t", "application/octet-stream", "--chunk-size", "3000", "--silent"}
+
+	// first chunk keeps failing
+	e.srv.CorruptChunks(1 + sdk.MAX_CHECKSUM_RETRIES)
+	if _, _, code := e.run(args...); code == EXIT_SUCCESS {
+		t.Fatalf("Expected upload to fail")
+	}
+	var journal uploadJournal
+	e.mustRunJSON(&journal, "artifact", "uploads")
+	if len(journal.Uploads) != 1 || journal.Uploads[0].Offset != 0 {
+		t.Fatalf("Expected one unfinished upload, but got %+v", journal)
+	}
+
+	e.srv.CorruptChunks(0)
+	id := strings.TrimSpace(e.mustRun(args...))
+	if id != journal.Uploads[0].ArtifactID {
+		t.Fatalf("Expected upload to artifact '%s' to resume, but got '%s'", journal.Uploads[0].ArtifactID, id)
+	}
+	if data, ok := e.srv.Artifact(id); !ok || !bytes.Equal(data, content) {
+		t.Fatalf("Expected server to hold uploaded content of artifact '%s'", id)
+	}
+	journal.Uploads = nil
+	e.mustRunJSON(&journal, "artifact", "uploads")
+	if len(journal.Uploads) != 0 {
+		t.Fatalf("Expected no unfinished uploads, but got %+v", journal)
+	}
+}
+
+func TestE2EInterruptUpload(t *testing.T) {
+	e := newE2E(t)
+	file := e.writeFile("data.bin", strings.Repeat("x", 10000))
+	e.interruptUpload("artifact", "create", "-f", file, "-t", "application/octet-stream",
+		"--chunk-size", "1000", "--limit-rate", "2KB/s", "--silent")
+
+	// the offset is recorded although the command's context was cancelled
+	var journal uploadJournal
+	e.mustRunJSON(&journal, "artifact", "uploads")
+	if len(journal.Uploads) != 1 {
+		t.Fatalf("Expected one unfinished upload, but got %+v", journal)
+	}
+	data, _ := e.srv.Artifact(journal.Uploads[0].ArtifactID)
+	if journal.Uploads[0].Offset == 0 || journal.Uploads[0].Offset != int64(len(data)) {
+		t.Fatalf("Expected offset %d to be recorded, but got %+v", len(data), journal.Uploads[0])
+	}
+}
+
+func TestE2EDelete(t *testing.T) {
+	e := newE2E(t)
+	file := e.writeFile("data.bin", strings.Repeat("x", 10000))
+	args := []string{"artifact", "create", "-f", file, "-t", "application/octet-stream", "--chunk-size", "3000", "--silent"}
+	unfinished := func() string {
+		e.srv.CorruptChunks(1 + sdk.MAX_CHECKSUM_RETRIES)
+		defer e.srv.CorruptChunks(0)
+		if _, _, code := e.run(args...); code == EXIT_SUCCESS {
+			t.Fatalf("Expected upload to fail")
+		}
+		var journal uploadJournal
+		e.mustRunJSON(&journal, "artifact", "uploads")
+		if len(journal.Uploads) != 1 {
+			t.Fatalf("Expected one unfinished upload, but got %+v", journal)
+		}
+		return journal.Uploads[0].ArtifactID
+	}
+
+	// abandoning an upload terminates it on the server
+	id := unfinished()
+	e.mustRun("artifact", "uploads", "abandon", id)
+	e.expectExitCode(EXIT_NOT_FOUND, "artifact", "download", id, "-f", filepath.Join(e.workDir, "out.bin"))
+
+	id = unfinished()
+	other := e.writeFile("other.txt", "other")
+	id2 := strings.TrimSpace(e.mustRun("artifact", "create", "-f", other, "-t", "text/plain", "--silent"))
+	if _, stderr, code := e.runWithInput("n\n", "artifact", "delete", id, id2); code != EXIT_SUCCESS || !strings.Contains(stderr, "Nothing deleted") {
+		t.Fatalf("Expected declined deletion to succeed without deleting, but got %d: %s", code, stderr)
+	}
+	if _, ok := e.srv.Artifact(id2); !ok {
+		t.Fatalf("Expected artifact to remain without confirmation")
+	}
+	// IDs on stdin can't be confirmed from there
+	if _, _, code := e.runWithInput(id2+"\ny\n", "artifact", "delete", "-"); code != EXIT_USAGE {
+		t.Fatalf("Expected exit code %d, but got %d", EXIT_USAGE, code)
+	}
+	if _, ok := e.srv.Artifact(id2); !ok {
+		t.Fatalf("Expected artifact to remain without '--yes'")
+	}
+	stdout, _, code := e.run("artifact", "delete", id, id2, "urn:ivcap:artifact:unknown", "--yes", "-o", "json")
+	var results []deleteResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil || code != EXIT_PARTIAL {
+		t.Fatalf("Expected partial success, but got %d: %s", code, stdout)
+	}
+	if len(results) != 3 || results[0].Status != "deleted" || results[1].Status != "deleted" || results[2].Status != "failed" {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+	for _, id := range []string{id, id2} {
+		if _, ok := e.srv.Artifact(id); ok {
+			t.Fatalf("Expected artifact '%s' to be deleted", id)
+		}
+	}
+	var journal uploadJournal
+	e.mustRunJSON(&journal, "artifact", "uploads")
+	if len(journal.Uploads) != 0 {
+		t.Fatalf("Expected no unfinished uploads, but got %+v", journal)
+	}
+}
+
+func TestE2ERecursiveUpload(t *testing.T) {
+	e := newE2E(t)
+	files := map[string]string{
+		"a.txt":       "hello",
+		"sub/b.txt":   "world",
+		"sub/c/d.txt": strings.Repeat("x", 5000),
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Join(e.workDir, "data", filepath.Dir(name)), 0755)
+		e.writeFile(filepath.Join("data", name), content)
+	}
+
+	var manifest []manifestEntry
+	e.mustRunJSON(&manifest, "artifact", "create", "--recursive", filepath.Join(e.workDir, "data"),
+		"--collection", "urn:test:collection", "--jobs", "2", "-t", "text/plain", "--silent")
+	if len(manifest) != len(files) {
+		t.Fatalf("Expected manifest for %d files, but got %+v", len(files), manifest)
+	}
+	for _, m := range manifest {
+		if data, ok := e.srv.Artifact(m.ArtifactID); !ok || string(data) != files[m.Path] {
+			t.Fatalf("Expected artifact '%s' to hold content of '%s'", m.ArtifactID, m.Path)
+		}
+	}
+
+	var artifact struct {
+		Name        string
+		Collections []string
+	}
+	e.mustRunJSON(&artifact, "artifact", "get", manifest[1].ArtifactID)
+	if artifact.Name != manifest[1].Path || len(artifact.Collections) != 1 || artifact.Collections[0] != "urn:test:collection" {
+		t.Fatalf("Unexpected artifact %+v", artifact)
+	}
+	var list struct {
+		Records []struct{ Aspect sdk.ArtifactPath }
+	}
+	e.mustRunJSON(&list, "metadata", "query", "-e", manifest[1].ArtifactID, "-s", sdk.ARTIFACT_PATH_SCHEMA)
+	if len(list.Records) != 1 || list.Records[0].Aspect.Path != manifest[1].Path {
+		t.Fatalf("Expected path '%s' as metadata, but got %+v", manifest[1].Path, list)
+	}
+}
+
+func TestE2ESync(t *testing.T) {
+	e := newE2E(t)
+	dir := filepath.Join(e.workDir, "data")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	e.writeFile("data/a.txt", "hello")
+	e.writeFile("data/sub/b.txt", "world")
+	collection := "urn:test:collection"
+
+	var manifest []manifestEntry
+	e.mustRunJSON(&manifest, "artifact", "create", "--recursive", dir, "--collection", collection, "-t", "text/plain", "--silent")
+	e.writeFile("data/a.txt", "hello again")
+	e.writeFile("data/c.txt", "new")
+
+	expect := map[string]string{"a.txt": SYNC_UPDATE, "c.txt": SYNC_UPLOAD, "sub/b.txt": SYNC_UNCHANGED}
+	check := func(actions []syncAction) {
+		t.Helper()
+		if len(actions) != len(expect) {
+			t.Fatalf("Expected %d actions, but got %+v", len(expect), actions)
+		}
+		for _, act := range actions {
+			if expect[act.Path] != act.Action || act.Error != "" {
+				t.Fatalf("Unexpected action %+v", act)
+			}
+		}
+	}
+	var plan []syncAction
+	e.mustRunJSON(&plan, "artifact", "sync", dir, collection, "--dry-run")
+	check(plan)
+
+	var actions []syncAction
+	e.mustRunJSON(&actions, "artifact", "sync", dir, collection, "-t", "text/plain", "--silent")
+	check(actions)
+	for _, act := range actions {
+		if act.Path == "a.txt" {
+			if data, _ := e.srv.Artifact(act.ArtifactID); string(data) != "hello again" {
+				t.Fatalf("Expected updated content, but got '%s'", data)
+			}
+		}
+	}
+
+	os.Remove(filepath.Join(dir, "sub", "b.txt"))
+	actions = nil
+	e.mustRunJSON(&actions, "artifact", "sync", dir, collection, "--download", "--silent")
+	expect = map[string]string{"a.txt": SYNC_UNCHANGED, "c.txt": SYNC_UNCHANGED, "sub/b.txt": SYNC_DOWNLOAD}
+	check(actions)
+	if data, err := os.ReadFile(filepath.Join(dir, "sub", "b.txt")); err != nil || string(data) != "world" {
+		t.Fatalf("Expected 'sub/b.txt' to be downloaded, but got '%s' (%v)", data, err)
+	}
+
+	// artifacts removed from the collection are no longer considered, despite their recorded path
+	for _, act := range actions {
+		if act.Path == "c.txt" {
+			e.mustRun("artifact", "collection", "remove", collection, act.ArtifactID)
+		}
+	}
+	plan = nil
+	e.mustRunJSON(&plan, "artifact", "sync", dir, collection, "--dry-run")
+	expect = map[string]string{"a.txt": SYNC_UNCHANGED, "c.txt": SYNC_UPLOAD, "sub/b.txt": SYNC_UNCHANGED}
+	check(plan)
+}
+
+func TestE2ESyncInterruptedUpload(t *testing.T) {
+	e := newE2E(t)
+	dir := filepath.Join(e.workDir, "data")
+	os.MkdirAll(dir, 0755)
+	e.writeFile("data/a.bin", strings.Repeat("x", 10000))
+	collection := "urn:test:collection"
+
+	upload := e.interruptUpload("artifact", "create", "--recursive", dir, "--collection", collection,
+		"-t", "application/octet-stream", "--chunk-size", "1000", "--limit-rate", "2KB/s", "--silent")
+
+	// resumes the interrupted upload and records its path, so that it's unchanged afterwards
+	var actions []syncAction
+	e.mustRunJSON(&actions, "artifact", "sync", dir, collection, "-t", "application/octet-stream", "--silent")
+	if len(actions) != 1 || actions[0].Action != SYNC_UPLOAD || actions[0].ArtifactID != upload.ArtifactID {
+		t.Fatalf("Expected interrupted upload to '%s' to resume, but got %+v", upload.ArtifactID, actions)
+	}
+	actions = nil
+	e.mustRunJSON(&actions, "artifact", "sync", dir, collection, "--dry-run")
+	if len(actions) != 1 || actions[0].Action != SYNC_UNCHANGED {
+		t.Fatalf("Expected 'a.bin' to be unchanged, but got %+v", actions)
+	}
+}
+
+func TestE2ESyncIgnoresPathOutsideDir(t *testing.T) {
+	e := newE2E(t)
+	dir := filepath.Join(e.workDir, "data")
+	os.MkdirAll(dir, 0755)
+	collection := "urn:test:collection"
+
+	file := e.writeFile("evil.txt", "evil")
+	id := strings.TrimSpace(e.mustRun("artifact", "create", "-f", file, "--collection", collection, "-t", "text/plain", "--silent"))
+	for i, path := range []string{"../escaped.txt", "/tmp/escaped.txt"} {
+		meta := e.writeFile(fmt.Sprintf("path%d.json", i), fmt.Sprintf(`{"$schema": "%s", "path": "%s"}`, sdk.ARTIFACT_PATH_SCHEMA, path))
+		e.mustRun("metadata", "add", id, "-f", meta, "--silent")
+
+		var actions []syncAction
+		e.mustRunJSON(&actions, "artifact", "sync", dir, collection, "--download", "--silent")
+		if len(actions) != 0 {
+			t.Fatalf("Expected artifact with path '%s' to be ignored, but got %+v", path, actions)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(e.workDir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Expected no file to be written outside of '%s'", dir)
+	}
+}
+
+func TestE2EDetectContentType(t *testing.T) {
+	e := newE2E(t)
+	// GeoTIFF with a single IFD holding only the GeoKey directory tag
+	geotiff := "II*\x00\x08\x00\x00\x00\x01\x00\xaf\x87\x03\x00\x04\x00\x00\x00\x00\x00\x00\x00"
+	files := map[string]string{
+		"table.csv":      "a,b\n1,2\n",
+		"shape.geojson":  `{"type": "FeatureCollection", "features": []}`,
+		"data.bin":       "\x89HDF\r\n\x1a\n",
+		"data.parquet":   "PAR1",
+		"image.tif":      geotiff,
+		"custom.dat":     "XYZ!content",
+		"store.zarr.zip": "PK\x03\x04",
+	}
+	os.MkdirAll(filepath.Join(e.workDir, "data"), 0755)
+	for name, content := range files {
+		e.writeFile(filepath.Join("data", name), content)
+	}
+	configFile := filepath.Join(e.configDir, CONFIG_FILE_DIR, CONFIG_FILE_NAME)
+	config, _ := ioutil.ReadFile(configFile)
+	config = append(config, "content-types:\n- content-type: application/x-custom\n  magic: \"58595a21\"\n"...)
+	ioutil.WriteFile(configFile, config, 0600)
+
+	var detected []detectedContentType
+	e.mustRunJSON(&detected, "artifact", "create", "--recursive", filepath.Join(e.workDir, "data"), "--detect-only")
+	expected := map[string]string{
+		"table.csv":      "text/csv",
+		"shape.geojson":  "application/geo+json",
+		"data.bin":       "application/x-hdf5",
+		"data.parquet":   "application/vnd.apache.parquet",
+		"image.tif":      "image/tiff; application=geotiff",
+		"custom.dat":     "application/x-custom",
+		"store.zarr.zip": "application/vnd.zarr",
+	}
+	if len(detected) != len(expected) {
+		t.Fatalf("Expected content types for %d files, but got %+v", len(expected), detected)
+	}
+	for _, d := range detected {
+		if expected[d.Path] != d.ContentType {
+			t.Fatalf("Expected '%s' for '%s', but got '%s'", expected[d.Path], d.Path, d.ContentType)
+		}
+	}
+	out := e.mustRun("artifact", "create", "-f", filepath.Join(e.workDir, "data", "table.csv"), "--detect-only")
+	if strings.TrimSpace(out) != "text/csv" {
+		t.Fatalf("Expected 'text/csv', but got '%s'", out)
+	}
+}
+
+func TestE2EMetadata(t *testing.T) {
+	e := newE2E(t)
+	entity := "urn:test:entity:1"
+	metaFile := e.writeFile("meta.json", `{"$schema": "urn:test:schema:a", "foo": 1}`)
+
+	recordID := strings.TrimSpace(e.mustRun("metadata", "add", entity, "-f", metaFile, "--silent"))
+	var rec struct {
+		RecordID string `json:"record-id"`
+		Aspect   map[string]interface{}
+	}
+	e.mustRunJSON(&rec, "metadata", "get", recordID)
+	if rec.RecordID != recordID || rec.Aspect["foo"] != float64(1) {
+		t.Fatalf("Unexpected record %+v", rec)
+	}
+
+	var list struct{ Records []struct{ Entity string } }
+	e.mustRunJSON(&list, "metadata", "query", "-s", "urn:test:schema")
+	if len(list.Records) != 1 || list.Records[0].Entity != entity {
+		t.Fatalf("Unexpected metadata %+v", list)
+	}
+	e.mustRun("metadata", "revoke", recordID)
+	list.Records = nil
+	e.mustRunJSON(&list, "metadata", "query", "-e", entity)
+	if len(list.Records) != 0 {
+		t.Fatalf("Expected revoked record to be gone, but got %+v", list)
+	}
+}
+
+func TestE2EExitCodes(t *testing.T) {
+	e := newE2E(t)
+	e.expectExitCode(EXIT_USAGE, "artifact", "get")
+	e.expectExitCode(EXIT_USAGE, "artifact", "list", "--no-such-flag")
+	e.expectExitCode(EXIT_NOT_FOUND, "artifact", "get", "urn:ivcap:artifact:unknown")
+	e.expectExitCode(EXIT_UNAUTHORIZED, "artifact", "list", "--access-token", "wrong")
+
+	_, stderr, _ := e.run("artifact", "get", "urn:ivcap:artifact:unknown", "-o", "json")
+	var report ErrorReport
+	if err := json.Unmarshal([]byte(stderr), &report); err != nil {
+		t.Fatalf("Expected error report on stderr, but got %s", stderr)
+	}
+	if report.Code != "not_found" || report.Status != 404 || report.RequestID == "" {
+		t.Fatalf("Unexpected error report %+v", report)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	adpt "github.com/reinventingscience/ivcap-cli/pkg/adapter"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// Exit codes returned by the CLI. See the 'Exit Codes' section in README.md
+const (
+	EXIT_SUCCESS      = 0   // Command completed successfully
+	EXIT_ERROR        = 1   // Any error not covered below
+	EXIT_USAGE        = 2   // Invalid flags, arguments or input file content, or request rejected by validation (400, 422)
+	EXIT_NOT_FOUND    = 3   // Requested resource or input file does not exist (404)
+	EXIT_UNAUTHORIZED = 4   // Missing, expired or insufficient credentials (401, 403)
+	EXIT_NETWORK      = 5   // Deployment could not be reached, timed out, is overloaded (429) or failed (5xx)
+	EXIT_PARTIAL      = 6   // Some, but not all items of a multi-item command succeeded
+	EXIT_CONFLICT     = 7   // Request conflicts with the current state of the resource (409)
+	EXIT_TIMEOUT      = 8   // Waited longer than allowed with '--wait-timeout'
+	EXIT_CANCELLED    = 130 // Command was interrupted with Ctrl-C
+)
+
+var exitCodeNames = map[int]string{
+	EXIT_ERROR:        "error",
+	EXIT_USAGE:        "usage",
+	EXIT_NOT_FOUND:    "not_found",
+	EXIT_UNAUTHORIZED: "unauthorized",
+	EXIT_NETWORK:      "network",
+	EXIT_PARTIAL:      "partial",
+	EXIT_CONFLICT:     "conflict",
+	EXIT_TIMEOUT:      "timeout",
+	EXIT_CANCELLED:    "cancelled",
+}
+
+// ExitError carries the exit code the CLI should terminate with
+type ExitError struct {
+	Code int
+	err  error
+}
+
+func (e *ExitError) Error() string { return e.err.Error() }
+
+func (e *ExitError) Unwrap() error { return e.err }
+
+// Returns an error which will terminate the CLI with exit code 'code'
+func newExitError(code int, format string, a ...interface{}) error {
+	return &ExitError{code, fmt.Errorf(format, a...)}
+}
+
+// PartialSuccessError is returned by commands operating on multiple items
+// when some of them failed.
+type PartialSuccessError struct {
+	Failed int
+	Total  int
+}
+
+func (e *PartialSuccessError) Error() string {
+	return fmt.Sprintf("%d of %d items failed", e.Failed, e.Total)
+}
+
+// Returns the exit code for 'err' according to its failure class
+func exitCodeFor(err error) int {
+	if err == nil {
+		return EXIT_SUCCESS
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	var partialErr *PartialSuccessError
+	if errors.As(err, &partialErr) {
+		return EXIT_PARTIAL
+	}
+	var cancelledErr *adpt.CancelledError
+	if errors.As(err, &cancelledErr) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return EXIT_NETWORK
+		}
+		return EXIT_CANCELLED
+	}
+	var notFoundErr *adpt.ResourceNotFoundError
+	var unauthorizedErr *adpt.UnauthorizedError
+	var forbiddenErr *adpt.ForbiddenError
+	var badRequestErr *adpt.BadRequestError
+	var conflictErr *adpt.ConflictError
+	var rateLimitedErr *adpt.RateLimitedError
+	var serverErr *adpt.ServerError
+	var clientErr *adpt.ClientError
+	var missingUrlErr *adpt.MissingUrlError
+	switch {
+	case errors.As(err, &notFoundErr):
+		return EXIT_NOT_FOUND
+	case errors.As(err, &unauthorizedErr), errors.As(err, &forbiddenErr):
+		return EXIT_UNAUTHORIZED
+	case errors.As(err, &badRequestErr), errors.As(err, &missingUrlErr):
+		return EXIT_USAGE
+	case errors.As(err, &conflictErr):
+		return EXIT_CONFLICT
+	case errors.As(err, &rateLimitedErr), errors.As(err, &serverErr), errors.As(err, &clientErr):
+		return EXIT_NETWORK
+	default:
+		return EXIT_ERROR
+	}
+}
+
+// Returns the exit code for failing to read an input file: EXIT_NOT_FOUND if it
+// doesn't exist, EXIT_ERROR if it can't be read, and EXIT_USAGE if its content is invalid
+func inputFileExitCode(err error) int {
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return EXIT_NOT_FOUND
+	case errors.As(err, &pathErr):
+		return EXIT_ERROR
+	default:
+		return EXIT_USAGE
+	}
+}
+
+// Marks errors reported by the argument validators of 'cmd' and all its
+// sub commands as usage errors
+func markArgErrorsAsUsage(cmd *cobra.Command) {
+	if validator := cmd.Args; validator != nil {
+		cmd.Args = func(c *cobra.Command, args []string) error {
+			if err := validator(c, args); err != nil {
+				return &ExitError{EXIT_USAGE, err}
+			}
+			return nil
+		}
+	}
+	for _, c := range cmd.Commands() {
+		markArgErrorsAsUsage(c)
+	}
+}
+
+// ErrorReport is the envelope printed to stderr when a command fails
+// while a structured output format ('-o json|yaml') is selected
+type ErrorReport struct {
+	// Failure class, such as 'not_found' or 'network'
+	Code string `json:"code" yaml:"code"`
+	// Exit code the CLI terminates with
+	ExitCode int    `json:"exit-code" yaml:"exit-code"`
+	Message  string `json:"message" yaml:"message"`
+	// API path of the failed request
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// HTTP status code of the failed request
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+	// ID reported by the server for this failure, useful when reporting issues
+	RequestID string `json:"request-id,omitempty" yaml:"request-id,omitempty"`
+	// Command may succeed if repeated
+	Retryable bool `json:"retryable" yaml:"retryable"`
+}
+
+func newErrorReport(code int, err error) *ErrorReport {
+	r := &ErrorReport{Code: exitCodeNames[code], ExitCode: code, Message: err.Error()}
+	if r.Code == "" {
+		r.Code = exitCodeNames[EXIT_ERROR]
+	}
+	var adptErr adpt.IAdapterError
+	if errors.As(err, &adptErr) {
+		r.Path = adptErr.Path()
+	}
+	var apiErr *adpt.ApiError
+	if errors.As(err, &apiErr) {
+		r.Status = apiErr.StatusCode
+		r.Retryable = apiErr.Temporary()
+		if apiErr.Fault != nil {
+			r.RequestID = apiErr.Fault.ID
+		}
+		if r.RequestID == "" && apiErr.Payload != nil {
+			r.RequestID = apiErr.Payload.Header("X-Request-Id")
+		}
+	} else {
+		r.Retryable = code == EXIT_NETWORK
+	}
+	return r
+}
+
+// Prints 'err' to stderr, either as plain text or, if a structured
+// output format is selected, as an ErrorReport.
+func reportError(code int, err error) {
+	var b []byte
+	var merr error
+	switch outputFormat {
+	case "json":
+		b, merr = json.MarshalIndent(newErrorReport(code, err), "", "  ")
+	case "yaml":
+		b, merr = yaml.Marshal(newErrorReport(code, err))
+	default:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	if merr != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, strings.TrimRight(string(b), "\n"))
+}
+
+// Prints 'msg' and terminates the CLI with exit code 'code'. Replaces
+// `cobra.CheckErr` which always exits with 1.
+func checkErr(code int, msg interface{}) {
+	if msg == nil {
+		return
+	}
+	err, ok := msg.(error)
+	if !ok {
+		err = fmt.Errorf("%v", msg)
+	}
+	reportError(code, err)
+	exit(code)
+}
+
+// Terminates the CLI with exit code 'code' after saving any
+// pending recordings. Failing to save them turns success into EXIT_ERROR.
+func exit(code int) {
+	if err := saveHarRecording(); err != nil {
+		if code != EXIT_SUCCESS {
+			// the actual failure got reported already
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		} else {
+			code = EXIT_ERROR
+			reportError(code, err)
+		}
+	}
+	os.Exit(code)
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -56,15 +57,6 @@ var logoutCmd = &cobra.Command{
 	},
 }
 
-type CaddyFaultResponse struct {
-	Name      string
-	Id        string
-	Message   string
-	Temporary bool
-	Timeout   bool
-	Fault     bool
-}
-
 type AuthInfo struct {
 	Version      int              `yaml:"version"`
 	ProviderList AuthProviderInfo `yaml:"auth"`
@@ -141,41 +133,75 @@ func getAccessToken(refreshIfExpired bool) (accessToken string) {
 		if !refreshIfExpired {
 			return ""
 		}
-		if ctxt.RefreshToken == "" {
-			// We don't have a refresh token for this context, so we fail early
-			cobra.CheckErr("Could not login - invalid credentials. Please use the login command to refresh your credentials")
+		reqCtxt, cancel := NewTimeoutContext()
+		defer cancel()
+		if _, err := refreshAccessToken(reqCtxt, ctxt); err != nil {
+			checkErr(exitCodeFor(err), err)
 		}
+	}
 
-		// Access token has expired, we have to refresh it
-		authProvider := getLoginInformation(ctxt)
-		authProvider.grantType = "refresh_token"
+	return ctxt.AccessToken
+}
 
-		if (authProvider.TokenURL != "") && (authProvider.ClientID != "") {
-			params := url.Values{
-				"refresh_token": {ctxt.RefreshToken},
-			}
-			tokenResponse := getTokenResponse(authProvider, params, ctxt, false)
-			if tokenResponse.ErrorString != "" {
-				logger.Warn("tokenResponse", log.String("error", tokenResponse.ErrorString))
-				cobra.CheckErr("oauth: Unexpected error from authentication provider")
-			}
+// Requests a new access token from the identity provider using the refresh
+// token stored in 'c' and persists the result in the config file. Returns an
+// UnauthorizedError if 'c' has no credentials which can be refreshed.
+func refreshAccessToken(ctxt context.Context, c *Context) (string, error) {
+	if c.RefreshToken == "" {
+		// We don't have a refresh token for this context, so we fail early
+		return "", adpt.NewUnauthorizedError("", "invalid credentials. Please use the login command to refresh your credentials")
+	}
 
-			ctxt.AccessToken = tokenResponse.AccessToken
-			if tokenResponse.RefreshToken != "" {
-				ctxt.RefreshToken = tokenResponse.RefreshToken
-			}
-			// Add a 10 second buffer to expiry to account for differences in clock time between client
-			// server and message transport time (oauth2 library does the same thing)
-			ctxt.AccessTokenExpiry = time.Now().Add(time.Second * time.Duration(tokenResponse.ExpiresIn-10))
+	authProvider, err := fetchLoginInformation(ctxt)
+	if err != nil {
+		return "", err
+	}
+	if authProvider.TokenURL == "" || authProvider.ClientID == "" {
+		return "", adpt.NewUnauthorizedError("", "authentication provider doesn't support refreshing credentials. Please use the login command to refresh your credentials")
+	}
+	authProvider.grantType = "refresh_token"
+	params := url.Values{
+		"refresh_token": {c.RefreshToken},
+	}
+	tokenResponse, err := requestTokens(ctxt, authProvider, params, false)
+	if err != nil {
+		return "", err
+	}
+	if tokenResponse.ErrorString != "" {
+		logger.Warn("tokenResponse", log.String("error", tokenResponse.ErrorString))
+		return "", adpt.NewUnauthorizedError(authProvider.TokenURL, "oauth: Unexpected error from authentication provider")
+	}
+
+	c.AccessToken = tokenResponse.AccessToken
+	if tokenResponse.RefreshToken != "" {
+		c.RefreshToken = tokenResponse.RefreshToken
+	}
+	// Add a 10 second buffer to expiry to account for differences in clock time between client
+	// server and message transport time (oauth2 library does the same thing)
+	c.AccessTokenExpiry = time.Now().Add(time.Second * time.Duration(tokenResponse.ExpiresIn-10))
 
-			// We also get an updated ID token, let's make sure we have the latest info
-			ParseIDToken(&tokenResponse, ctxt, authProvider.JwksURL)
-			SetContext(ctxt, true)
-			logger.Info("Successfully acquired new access token.", log.String("expires", ctxt.AccessTokenExpiry.Format(time.RFC822)))
-		} // Access token has not expired, let's just use it
+	// We also get an updated ID token, let's make sure we have the latest info
+	if err = parseIDToken(ctxt, &tokenResponse, c, authProvider.JwksURL); err != nil {
+		return "", err
 	}
+	SetContext(c, true)
+	logger.Info("Successfully acquired new access token.", log.String("expires", c.AccessTokenExpiry.Format(time.RFC822)))
+	return c.AccessToken, nil
+}
 
-	return ctxt.AccessToken
+// Passed to the adapter to obtain a new access token when the server
+// rejects the current one before its recorded expiry.
+func tokenRefresher(ctxt context.Context, logger *log.Logger) (string, error) {
+	if accessTokenProvided {
+		return "", adpt.NewUnauthorizedError("", "cannot refresh access token provided via '--access-token' or environment")
+	}
+	logger.Info("access token rejected, refreshing")
+	token, err := refreshAccessToken(ctxt, GetActiveContext())
+	if err != nil {
+		return "", err
+	}
+	accessToken = token
+	return accessToken, nil
 }
 
 func IsAuthorised() bool {
@@ -183,58 +209,74 @@ func IsAuthorised() bool {
 }
 
 func getTokenResponse(authProvider *AuthProvider, params url.Values, ctxt *Context, allowStatusForbidden bool) (tokenResponse deviceTokenResponse) {
+	reqCtxt, cancel := NewTimeoutContext()
+	defer cancel()
+	tokenResponse, err := requestTokens(reqCtxt, authProvider, params, allowStatusForbidden)
+	if err != nil {
+		checkErr(exitCodeFor(err), err)
+	}
+	return
+}
+
+// Requests tokens from the authentication provider's token endpoint. Replies with
+// status 403 are returned as token response if 'allowStatusForbidden' is set, as they
+// carry the state of a pending device login.
+func requestTokens(ctxt context.Context, authProvider *AuthProvider, params url.Values, allowStatusForbidden bool) (tokenResponse deviceTokenResponse, err error) {
 	adapter := CreateAdapter(false)
 	params.Set("grant_type", authProvider.grantType)
 	params.Set("client_id", authProvider.ClientID)
 
-	var pyld adpt.Payload
-	var err error
-	pyld, err = (*adapter).PostForm(NewTimeoutContext(), authProvider.TokenURL, params, nil, logger)
+	pyld, err := (*adapter).PostForm(ctxt, authProvider.TokenURL, params, nil, logger)
 	if err != nil {
-		if apiErr, ok := err.(*adpt.ApiError); ok && allowStatusForbidden {
-			if apiErr.StatusCode == http.StatusForbidden {
-				pyld = apiErr.Payload
-			} else {
-				cobra.CheckErr(fmt.Sprintf("Cannot obtain OAuth Token - %s", err))
-			}
+		var apiErr *adpt.ApiError
+		if errors.As(err, &apiErr) && allowStatusForbidden && apiErr.StatusCode == http.StatusForbidden {
+			pyld = apiErr.Payload
 		} else {
-			cobra.CheckErr(fmt.Sprintf("Cannot obtain OAuth Token - %s", err))
-			return // never reached
+			err = fmt.Errorf("Cannot obtain OAuth Token - %w", err)
+			return
 		}
 	}
 
 	if err = pyld.AsType(&tokenResponse); err != nil {
 		logger.Error("while parsing 'deviceTokenResponse'", log.String("pyld", string(pyld.AsBytes())))
-		cobra.CheckErr("oauth: Cannot decode token response")
+		err = adpt.NewUnauthorizedError(authProvider.TokenURL, "oauth: Cannot decode token response")
 		return
 	}
 
 	switch tokenResponse.ErrorString {
 	case "expired_token":
-		cobra.CheckErr("The login process was not completed in time - please login again")
+		err = adpt.NewUnauthorizedError(authProvider.TokenURL, "The login process was not completed in time - please login again")
 	case "access_denied":
-		cobra.CheckErr("Could not login - access was denied")
+		err = adpt.NewUnauthorizedError(authProvider.TokenURL, "Could not login - access was denied")
 	case "invalid_grant":
-		cobra.CheckErr("Could not login - expired credentials. Please use the login command to refresh your credentials")
+		err = adpt.NewUnauthorizedError(authProvider.TokenURL, "Could not login - expired credentials. Please use the login command to refresh your credentials")
 	}
 	return
 }
 
 func getLoginInformation(ctxt *Context) (authProvider *AuthProvider) {
+	reqCtxt, cancel := NewTimeoutContext()
+	defer cancel()
+	authProvider, err := fetchLoginInformation(reqCtxt)
+	if err != nil {
+		checkErr(exitCodeFor(err), err)
+	}
+	return
+}
+
+// Returns the authentication provider the deployment asks to use
+func fetchLoginInformation(ctxt context.Context) (*AuthProvider, error) {
 	adpt := CreateAdapter(false)
-	pyld, err := (*adpt).Get(NewTimeoutContext(), "/1/authinfo.yaml", logger)
+	pyld, err := (*adpt).Get(ctxt, "/1/authinfo.yaml", logger)
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("oauth: Cannot retrieve authentication info from server - %s", err))
-		return
+		return nil, fmt.Errorf("oauth: Cannot retrieve authentication info from server - %w", err)
 	}
 	var ai AuthInfo
 	if err = yaml.Unmarshal(pyld.AsBytes(), &ai); err != nil {
-		cobra.CheckErr(fmt.Sprintf("oauth: Cannot parse authentication info from server. - %s", err))
-		return
+		return nil, fmt.Errorf("oauth: Cannot parse authentication info from server. - %w", err)
 	}
 	if ai.Version != 1 {
-		cobra.CheckErr("oauth: Client out of date: Please update this application")
-		return
+		return nil, errors.New("oauth: Client out of date: Please update this application")
 	}
 	providers := ai.ProviderList.AuthProviders
 	defProvider := ai.ProviderList.DefaultProviderId
@@ -242,28 +284,24 @@ func getLoginInformation(ctxt *Context) (authProvider *AuthProvider) {
 		return verifyProviderInfo(&provider)
 	}
 	if defProvider != "" {
-		cobra.CheckErr(fmt.Sprintf("oauth: Undeclared authentication provider '%s' returned", defProvider))
-		return
+		return nil, fmt.Errorf("oauth: Undeclared authentication provider '%s' returned", defProvider)
 	}
 	// If no default provider is given, just pick the first one
 	for _, p := range providers {
 		return verifyProviderInfo(&p)
 	}
-	cobra.CheckErr("oauth: Cannot extract a suitable authentication provider")
-	return // never get here
+	return nil, errors.New("oauth: Cannot extract a suitable authentication provider")
 }
 
-func verifyProviderInfo(p *AuthProvider) *AuthProvider {
-	f := func(name string, urls string) {
+func verifyProviderInfo(p *AuthProvider) (*AuthProvider, error) {
+	for name, urls := range map[string]string{
+		"LoginURL": p.LoginURL, "TokenURL": p.TokenURL, "CodeURL": p.CodeURL, "JwksURL": p.JwksURL,
+	} {
 		if _, e := url.ParseRequestURI(urls); e != nil {
-			cobra.CheckErr(fmt.Sprintf("oauth: Authentication provider's %s '%s' is not a valid URL - %s", name, urls, e))
+			return nil, fmt.Errorf("oauth: Authentication provider's %s '%s' is not a valid URL - %w", name, urls, e)
 		}
 	}
-	f("LoginURL", p.LoginURL)
-	f("TokenURL", p.TokenURL)
-	f("CodeURL", p.CodeURL)
-	f("JwksURL", p.JwksURL)
-	return p
+	return p, nil
 }
 
 func requestDeviceCode(authProvider *AuthProvider) (code *DeviceCode) {
@@ -273,16 +311,18 @@ func requestDeviceCode(authProvider *AuthProvider) (code *DeviceCode) {
 		"scope":     {authProvider.scopes},
 		"audience":  {authProvider.audience},
 	}
-	pyld, err := (*adpt).PostForm(NewTimeoutContext(), authProvider.CodeURL, params, nil, logger)
+	ctxt, cancel := NewTimeoutContext()
+	defer cancel()
+	pyld, err := (*adpt).PostForm(ctxt, authProvider.CodeURL, params, nil, logger)
 	if err != nil {
-		cobra.CheckErr("oauth: Error while requesting device code from authentication provider")
+		checkErr(exitCodeFor(err), "oauth: Error while requesting device code from authentication provider")
 		return
 	}
 
 	var dc DeviceCode
 	if err = pyld.AsType(&dc); err != nil {
 		logger.Error("while parsing 'DeviceCode'", log.String("pyld", string(pyld.AsBytes())))
-		cobra.CheckErr("oauth: Cannot understand device information returned from authentication provider")
+		checkErr(EXIT_ERROR, "oauth: Cannot understand device information returned from authentication provider")
 		return
 	}
 	return &dc
@@ -313,7 +353,7 @@ func waitForTokens(authProvider *AuthProvider, deviceCode *DeviceCode, ctxt *Con
 			// the wait interval
 			deviceCode.Interval *= 2
 		default:
-			cobra.CheckErr(fmt.Sprintf("oauth: Authentication provider returned unexpected error '%s'", tokenResponse.ErrorString))
+			checkErr(EXIT_UNAUTHORIZED, fmt.Sprintf("oauth: Authentication provider returned unexpected error '%s'", tokenResponse.ErrorString))
 		}
 
 		elapsedTime := int64(time.Since(startTime).Seconds())
@@ -323,18 +363,30 @@ func waitForTokens(authProvider *AuthProvider, deviceCode *DeviceCode, ctxt *Con
 		lastElapsedTime = elapsedTime
 
 		// We sleep until we're allowed to poll again
-		time.Sleep(time.Duration(deviceCode.Interval) * time.Second)
+		select {
+		case <-time.After(time.Duration(deviceCode.Interval) * time.Second):
+		case <-appContext().Done():
+			checkErr(EXIT_CANCELLED, "Login was cancelled")
+		}
 	}
 }
 
 func ParseIDToken(tokenResponse *deviceTokenResponse, ctxt *Context, jwksURL string) {
+	if err := parseIDToken(appContext(), tokenResponse, ctxt, jwksURL); err != nil {
+		checkErr(exitCodeFor(err), err)
+	}
+}
+
+// Verifies the ID token in 'tokenResponse' and saves the account details it
+// carries in 'c'
+func parseIDToken(ctxt context.Context, tokenResponse *deviceTokenResponse, c *Context, jwksURL string) error {
 	// Lookup the public key to verify the signature (and check we have a valid token)
 
 	// TODO: Download and cache the jwks data rather than download it on every login / token
 	// refresh
-	jwks, err := keyfunc.Get(jwksURL, keyfunc.Options{})
+	jwks, err := keyfunc.Get(jwksURL, keyfunc.Options{Ctx: ctxt})
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("cannot load the JWKS - %s", err))
+		return newExitError(EXIT_NETWORK, "cannot load the JWKS - %w", err)
 	}
 	idToken, err := jwt.ParseWithClaims(tokenResponse.IDToken, &CustomIdClaims{}, jwks.Keyfunc)
 	if err != nil {
@@ -342,34 +394,38 @@ func ParseIDToken(tokenResponse *deviceTokenResponse, ctxt *Context, jwksURL str
 			// let's wait a bit and try again as this is most likely due to clock shifts as we immediately check
 			// token after it has been created.
 			logger.Info("oauth: Waiting a few seconds as token is not valid yet")
-			time.Sleep(time.Duration(3 * time.Second))
-			ParseIDToken(tokenResponse, ctxt, jwksURL)
-			return
+			select {
+			case <-time.After(3 * time.Second):
+			case <-ctxt.Done():
+				return ctxt.Err()
+			}
+			return parseIDToken(ctxt, tokenResponse, c, jwksURL)
 		} else if errors.Is(err, jwt.ErrTokenMalformed) {
-			cobra.CheckErr(fmt.Sprintf("malformed ID Token received - %s", err))
+			return adpt.NewUnauthorizedError("", fmt.Sprintf("malformed ID Token received - %s", err))
 		} else if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
 			// Token is either expired or not active yet
-			cobra.CheckErr(fmt.Sprintf("expired ID Token received - %s", err))
+			return adpt.NewUnauthorizedError("", fmt.Sprintf("expired ID Token received - %s", err))
 		} else {
-			cobra.CheckErr(fmt.Sprintf("cannot verify ID token - %s", err))
+			return adpt.NewUnauthorizedError("", fmt.Sprintf("cannot verify ID token - %s", err))
 		}
 	}
 
 	if idToken == nil {
-		cobra.CheckErr("Should never happen. No 'idToken' and no error")
+		return errors.New("Should never happen. No 'idToken' and no error")
 	}
 	if claims, ok := idToken.Claims.(*CustomIdClaims); ok && idToken.Valid {
 		// Save the data from the ID token into the config/context
-		ctxt.AccountName = claims.Name
-		ctxt.Email = claims.Email
-		ctxt.AccountNickName = claims.Nickname
-		ctxt.AccountID = fmt.Sprintf("urn:%s:account:%s", URN_PREFIX, claims.AccountID)
+		c.AccountName = claims.Name
+		c.Email = claims.Email
+		c.AccountNickName = claims.Nickname
+		c.AccountID = fmt.Sprintf("urn:%s:account:%s", URN_PREFIX, claims.AccountID)
 		providerID := claims.ProviderID
 		if providerID == "" {
 			providerID = claims.AccountID
 		}
-		ctxt.ProviderID = fmt.Sprintf("urn:%s:provider:%s", URN_PREFIX, providerID)
+		c.ProviderID = fmt.Sprintf("urn:%s:provider:%s", URN_PREFIX, providerID)
 	}
+	return nil
 }
 
 func login(_ *cobra.Command, args []string) {
@@ -388,7 +444,7 @@ func login(_ *cobra.Command, args []string) {
 	// Show QR code for authenticating via a web browser
 	qrCode, err := qrcode.New(deviceCode.VerificationURLComplete, qrcode.Medium)
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("cannot create QR code - %s", err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("cannot create QR code - %s", err))
 	}
 	qrCodeStrings := qrCode.ToSmallString(true)
 
@@ -15,7 +15,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"time"
 
@@ -95,7 +94,7 @@ var (
 		Args:    cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			recordID := GetHistory(args[0])
-			ctxt := context.Background()
+			ctxt := cmd.Context()
 			if res, err := sdk.GetMetadata(ctxt, recordID, CreateAdapter(true), logger); err == nil {
 				a.ReplyPrinter(res, outputFormat == "yaml")
 				return nil
@@ -113,7 +112,7 @@ var (
 		Args:    cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			recordID := GetHistory(args[0])
-			ctxt := context.Background()
+			ctxt := cmd.Context()
 			_, err = sdk.RevokeMetadata(ctxt, recordID, CreateAdapter(true), logger)
 			return
 		},
@@ -126,7 +125,7 @@ var (
 		Long:    `.....`,
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			if entityURN == "" && schemaPrefix == "" {
-				cobra.CheckErr("Need at least one of '--schema' or '--entity'")
+				checkErr(EXIT_USAGE, "Need at least one of '--schema' or '--entity'")
 			}
 			if entityURN != "" {
 				entityURN = GetHistory(entityURN)
@@ -135,11 +134,11 @@ var (
 			if atTime != "" {
 				t, err := dateparse.ParseLocal(atTime)
 				if err != nil {
-					cobra.CheckErr(fmt.Sprintf("Can't parse '%s' into a date - %s", atTime, err))
+					checkErr(EXIT_USAGE, fmt.Sprintf("Can't parse '%s' into a date - %s", atTime, err))
 				}
 				ts = &t
 			}
-			ctxt := context.Background()
+			ctxt := cmd.Context()
 			if list, res, err := sdk.ListMetadata(ctxt, entityURN, schemaPrefix, ts, CreateAdapter(true), logger); err == nil {
 				switch outputFormat {
 				case "json":
@@ -161,12 +160,12 @@ func addUpdateCmd(isAdd bool, cmd *cobra.Command, args []string) (err error) {
 	entity := args[0]
 	pyld, err := payloadFromFile(metaFile, inputFormat)
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("While reading metadata file '%s' - %s", metaFile, err))
+		checkErr(inputFileExitCode(err), fmt.Sprintf("While reading metadata file '%s' - %s", metaFile, err))
 	}
 
 	meta, err := pyld.AsObject()
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("Cannot parse meta file '%s' - %s", metaFile, err))
+		checkErr(EXIT_USAGE, fmt.Sprintf("Cannot parse meta file '%s' - %s", metaFile, err))
 	}
 	var schema string
 	schema = schemaURN
@@ -174,17 +173,17 @@ func addUpdateCmd(isAdd bool, cmd *cobra.Command, args []string) (err error) {
 		if s, ok := meta["$schema"]; ok {
 			schema = fmt.Sprintf("%s", s)
 		} else {
-			cobra.CheckErr("Missing schema name")
+			checkErr(EXIT_USAGE, "Missing schema name")
 		}
 	}
 	logger.Debug("add/update meta", log.String("entity", entity), log.String("schema", schema), log.Reflect("pyld", meta))
-	ctxt := context.Background()
+	ctxt := cmd.Context()
 	if res, err := sdk.AddUpdateMetadata(ctxt, isAdd, entity, schema, pyld.AsBytes(), CreateAdapter(true), logger); err == nil {
 		if silent {
 			if m, err := res.AsObject(); err == nil {
 				fmt.Printf("%s\n", m["record-id"])
 			} else {
-				cobra.CheckErr(fmt.Sprintf("Parsing reply: %s", res.AsBytes()))
+				checkErr(EXIT_ERROR, fmt.Sprintf("Parsing reply: %s", res.AsBytes()))
 			}
 		} else {
 			a.ReplyPrinter(res, outputFormat == "yaml")
@@ -19,9 +19,11 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	meta "github.com/reinventingscience/ivcap-core-api/http/metadata"
 	api "github.com/reinventingscience/ivcap-core-api/http/order"
+	srv "github.com/reinventingscience/ivcap-core-api/http/service"
 
 	sdk "github.com/reinventingscience/ivcap-cli/pkg"
 	a "github.com/reinventingscience/ivcap-cli/pkg/adapter"
@@ -50,12 +52,32 @@ func init() {
 	createOrderCmd.Flags().StringVarP(&outputFormat, "output", "o", "short", "format to use for list (short, yaml, json)")
 	createOrderCmd.Flags().StringVar(&accountID, "account-id", "", "override the account ID to use for the order")
 	createOrderCmd.Flags().BoolVar(&skipParameterCheck, "skip-parameter-check", false, "fskip checking order paramters first ONLY USE FOR TESTING")
+	createOrderCmd.Flags().StringVarP(&orderParamFile, "file", "f", "", "Path to YAML or JSON file containing parameters ('-' for stdin)")
+	createOrderCmd.Flags().StringVar(&inputFormat, "format", "json", "Format of parameter file [json, yaml]")
+	createOrderCmd.Flags().BoolVar(&orderTemplate, "template", false, "print a parameter file for the service instead of creating an order")
+	createOrderCmd.Flags().BoolVarP(&interactiveOrder, "interactive", "i", false, "ask for the value of every parameter of the service")
+	createOrderCmd.Flags().BoolVar(&waitForOrder, "wait", false, "wait for the order to finish and fail if it doesn't succeed")
+	createOrderCmd.Flags().IntVar(&waitTimeout, "wait-timeout", 0, "max. seconds to wait with '--wait' (0 waits until finished)")
+	createOrderCmd.Flags().Int64Var(&chunkSize, "chunk-size", DEF_CHUNK_SIZE, "Chunk size for splitting large '@file:' parameters")
+	createOrderCmd.Flags().StringVar(&checksum, "checksum", sdk.CHECKSUM_SHA256, "Checksum algorithm to verify uploaded '@file:' parameters (sha256, md5, none)")
+
+	// WAIT
+	orderCmd.AddCommand(waitOrderCmd)
+	waitOrderCmd.Flags().StringVarP(&outputFormat, "output", "o", "short", "format to use for list (short, yaml, json)")
+	waitOrderCmd.Flags().IntVar(&waitTimeout, "wait-timeout", 0, "max. seconds to wait (0 waits until finished)")
 }
 
+const (
+	ORDER_WAIT_INITIAL_INTERVAL = 1 * time.Second
+	ORDER_WAIT_MAX_INTERVAL     = 30 * time.Second
+)
+
 var (
 	name               string
 	accountID          string
 	skipParameterCheck bool
+	waitForOrder       bool
+	waitTimeout        int
 
 	orderCmd = &cobra.Command{
 		Use:     "order",
@@ -79,13 +101,13 @@ var (
 
 			switch outputFormat {
 			case "json", "yaml":
-				if res, err := sdk.ListOrdersRaw(context.Background(), req, CreateAdapter(true), logger); err == nil {
+				if res, err := sdk.ListOrdersRaw(cmd.Context(), req, CreateAdapter(true), logger); err == nil {
 					a.ReplyPrinter(res, outputFormat == "yaml")
 				} else {
 					return err
 				}
 			default:
-				if list, err := sdk.ListOrders(context.Background(), req, CreateAdapter(true), logger); err == nil {
+				if list, err := sdk.ListOrders(cmd.Context(), req, CreateAdapter(true), logger); err == nil {
 					printOrdersTable(list, false)
 				} else {
 					return err
@@ -107,14 +129,14 @@ var (
 
 			switch outputFormat {
 			case "json", "yaml":
-				if res, err := sdk.ReadOrderRaw(context.Background(), req, adapter, logger); err == nil {
+				if res, err := sdk.ReadOrderRaw(cmd.Context(), req, adapter, logger); err == nil {
 					a.ReplyPrinter(res, outputFormat == "yaml")
 				} else {
 					return err
 				}
 			default:
-				if order, err := sdk.ReadOrder(context.Background(), req, adapter, logger); err == nil {
-					if meta, _, err := sdk.ListMetadata(context.Background(), recordID, "", nil, adapter, logger); err == nil {
+				if order, err := sdk.ReadOrder(cmd.Context(), req, adapter, logger); err == nil {
+					if meta, _, err := sdk.ListMetadata(cmd.Context(), recordID, "", nil, adapter, logger); err == nil {
 						printOrder(order, meta, false)
 					} else {
 						return err
@@ -139,38 +161,63 @@ contains spaces, put it into quotes which will not be removed by your shell.
 An example:
 
   ivcap order create --name "test order" ivcap:service:d939b74d-0070-59a4-a832-36c5c07e657d msg="Hello World"
-	
+
+Parameters can also be read from a YAML or JSON file with '--file', which maps
+parameter names to their values. Parameters given on the command line override
+the ones in the file. Use '--template' to print such a file listing all parameters
+of the service, together with their descriptions and defaults:
+
+  ivcap order create --template ivcap:service:d939b74d-0070-59a4-a832-36c5c07e657d > params.yaml
+  ivcap order create -f params.yaml ivcap:service:d939b74d-0070-59a4-a832-36c5c07e657d msg="Hi"
+
+Parameter values starting with '@file:' name a local file, which is uploaded as a new
+artifact, and replaced by the artifact's URN. For parameters of type 'artifact', the
+path of an existing file can also be given directly:
+
+  ivcap order create ivcap:service:d939b74d-0070-59a4-a832-36c5c07e657d input=@file:./input.nc
+
+With '--interactive', you are asked for every parameter in turn, with its description,
+unit, default and options shown. Values from '--file' and the command line are offered
+instead of the defaults. The parameters can be saved to a parameter file before the
+order is submitted.
 `,
 		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
-			ctxt := context.Background()
+			ctxt := cmd.Context()
 			serviceId := GetHistory(args[0])
+			if err = checkChunkSize(cmd); err != nil {
+				return err
+			}
+			if err = checkWaitTimeout(); err != nil {
+				return err
+			}
 
-			var paramSet = map[string]bool{}
-			if !skipParameterCheck {
+			params, err := getOrderParameters(args[1:])
+			if err != nil {
+				return err
+			}
+			var service *srv.ReadResponseBody
+			if orderTemplate || interactiveOrder || !skipParameterCheck {
 				// fetch defined parameters to do some early verification
-				service, err := sdk.ReadService(ctxt, &sdk.ReadServiceRequest{Id: serviceId}, CreateAdapter(true), logger)
-				if err != nil {
+				if service, err = sdk.ReadService(ctxt, &sdk.ReadServiceRequest{Id: serviceId}, CreateAdapter(true), logger); err != nil {
 					return err
 				}
-				for _, p := range service.Parameters {
-					paramSet[*p.Name] = true
+				if orderTemplate {
+					return printOrderTemplate(service, params)
 				}
-			}
-			params := make([]*api.ParameterT, len(args)-1)
-			for i, ps := range args[1:] {
-				pa := strings.SplitN(ps, "=", 2)
-				if len(pa) != 2 {
-					cobra.CheckErr(fmt.Sprintf("cannot parse parameter argument '%s'", ps))
+				if interactiveOrder {
+					if params, err = promptOrderParameters(service, params); err != nil {
+						return err
+					}
 				}
-				name := pa[0]
-				value := pa[1]
 				if !skipParameterCheck {
-					if _, ok := paramSet[name]; !ok {
-						cobra.CheckErr(fmt.Sprintf("parameter '%s' is not defined by the requested service", name))
+					if params, err = validateOrderParameters(service, params); err != nil {
+						return err
 					}
 				}
-				params[i] = &api.ParameterT{Name: &name, Value: &value}
+			}
+			if err = uploadParameterFiles(ctxt, service, params); err != nil {
+				return err
 			}
 
 			if accountID == "" {
@@ -184,6 +231,16 @@ An example:
 			if name != "" {
 				req.Name = &name
 			}
+			if waitForOrder {
+				res, err := sdk.CreateOrder(ctxt, req, CreateAdapter(true), logger)
+				if err != nil {
+					return err
+				}
+				if outputFormat != "json" && outputFormat != "yaml" {
+					fmt.Printf("Order '%s' with status '%s' submitted.\n", *res.ID, *res.Status)
+				}
+				return waitAndPrintOrder(cmd, *res.ID)
+			}
 			switch outputFormat {
 			case "json", "yaml":
 				if res, err := sdk.CreateOrderRaw(ctxt, req, CreateAdapter(true), logger); err == nil {
@@ -201,8 +258,134 @@ An example:
 			return nil
 		},
 	}
+
+	waitOrderCmd = &cobra.Command{
+		Use:     "wait [flags] order-id",
+		Aliases: []string{"w"},
+		Short:   "Wait for an order to finish",
+		Long: `Wait for an order to either succeed or fail, showing its current status while
+waiting. The command exits with a non-zero code if the order failed, or if it is
+still running when the time given with '--wait-timeout' has passed. Without
+'--wait-timeout', it waits until the order is finished or the command is interrupted.
+
+An example:
+
+  ivcap order wait urn:ivcap:order:81b204e8-c404-499e-bc19-d78518a5a3dc && ivcap order get @1
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkWaitTimeout(); err != nil {
+				return err
+			}
+			return waitAndPrintOrder(cmd, GetHistory(args[0]))
+		},
+	}
 )
 
+// Returns a usage error if '--wait-timeout' is negative
+func checkWaitTimeout() error {
+	if waitTimeout < 0 {
+		return newExitError(EXIT_USAGE, "'--wait-timeout' can't be negative")
+	}
+	return nil
+}
+
+// Waits for order 'orderID' to finish and prints it. Returns an error if it didn't succeed.
+func waitAndPrintOrder(cmd *cobra.Command, orderID string) error {
+	ctxt := cmd.Context()
+	if waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctxt, cancel = context.WithTimeout(ctxt, time.Duration(waitTimeout)*time.Second)
+		defer cancel()
+	}
+	adapter := CreateAdapter(true)
+	order, err := waitForOrderToFinish(ctxt, orderID, adapter)
+	if err != nil {
+		return err
+	}
+	status := safeString(order.Status)
+	switch outputFormat {
+	case "json", "yaml":
+		res, err := sdk.ReadOrderRaw(cmd.Context(), &sdk.ReadOrderRequest{Id: orderID}, adapter, logger)
+		if err != nil {
+			return err
+		}
+		a.ReplyPrinter(res, outputFormat == "yaml")
+	default:
+		fmt.Printf("Order '%s' finished with status '%s'.\n", orderID, status)
+	}
+	if !isOrderSucceeded(status) {
+		return newExitError(EXIT_ERROR, "order '%s' finished with status '%s'", orderID, status)
+	}
+	return nil
+}
+
+// Polls order 'orderID', with increasing intervals, until it is in a terminal state,
+// and returns it. Unless '--silent', its status is shown on stderr while waiting.
+func waitForOrderToFinish(ctxt context.Context, orderID string, adapter *a.Adapter) (*api.ReadResponseBody, error) {
+	start := time.Now()
+	interval := ORDER_WAIT_INITIAL_INTERVAL
+	showStatus := func(status string, done bool) {
+		if silent {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\rOrder '%s' is '%s' (%v)   ", orderID, status, time.Since(start).Round(time.Second))
+		if done {
+			fmt.Fprintf(os.Stderr, "\n")
+		}
+	}
+	status := "unknown"
+	for {
+		order, err := sdk.ReadOrder(ctxt, &sdk.ReadOrderRequest{Id: orderID}, adapter, logger)
+		if err != nil {
+			if ctxt.Err() == nil {
+				return nil, err
+			}
+		} else {
+			status = safeString(order.Status)
+			if isOrderFinished(status) {
+				showStatus(status, true)
+				return order, nil
+			}
+			showStatus(status, false)
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctxt.Done():
+			timer.Stop()
+			showStatus(status, true)
+			if ctxt.Err() == context.DeadlineExceeded {
+				return nil, newExitError(EXIT_TIMEOUT, "order '%s' is still '%s' after waiting %ds", orderID, status, waitTimeout)
+			}
+			return nil, newExitError(EXIT_CANCELLED, "stopped waiting for order '%s'", orderID)
+		}
+		if interval *= 2; interval > ORDER_WAIT_MAX_INTERVAL {
+			interval = ORDER_WAIT_MAX_INTERVAL
+		}
+	}
+}
+
+// Returns true if an order with 'status' will not change anymore
+func isOrderFinished(status string) bool {
+	switch strings.ToLower(status) {
+	case "succeeded", "finished", "failed", "error":
+		return true
+	default:
+		return false
+	}
+}
+
+func isOrderSucceeded(status string) bool {
+	switch strings.ToLower(status) {
+	case "succeeded", "finished":
+		return true
+	default:
+		return false
+	}
+}
+
 func printOrdersTable(list *api.ListResponseBody, wide bool) {
 	srv2name := make(map[string]string)
 	rows := make([]table.Row, len(list.Orders))
@@ -0,0 +1,171 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	api "github.com/reinventingscience/ivcap-core-api/http/order"
+	srv "github.com/reinventingscience/ivcap-core-api/http/service"
+)
+
+var interactiveOrder bool
+
+// Asks the user on the terminal for the value of every parameter of 'service', offering
+// the value in 'params', or otherwise the default. Once all are entered, they are shown
+// for confirmation and can be saved to a parameter file. Returns the parameters to order
+// with, or an EXIT_CANCELLED error if the user didn't confirm them.
+func promptOrderParameters(service *srv.ReadResponseBody, params []*api.ParameterT) ([]*api.ParameterT, error) {
+	given := map[string]string{}
+	for _, p := range params {
+		given[*p.Name] = *p.Value
+	}
+	cancelled := newExitError(EXIT_CANCELLED, "order not submitted")
+
+	fmt.Fprintf(os.Stderr, "Parameters for service '%s' - press enter to accept the value in [brackets]\n",
+		safeString(service.Name))
+	var answers []*api.ParameterT
+	for _, d := range service.Parameters {
+		if d.Name == nil || (d.Constant != nil && *d.Constant) {
+			continue
+		}
+		value, ok := promptParameter(d, given)
+		if !ok {
+			return nil, cancelled
+		}
+		if value != nil {
+			answers = append(answers, &api.ParameterT{Name: d.Name, Value: value})
+		}
+	}
+
+	fmt.Fprintln(os.Stderr)
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stderr)
+	t.AppendHeader(table.Row{"Parameter", "Value"})
+	for _, p := range answers {
+		t.AppendRow(table.Row{*p.Name, *p.Value})
+	}
+	t.Render()
+
+	fileName, ok := prompt("Save parameters to file (leave empty to skip): ")
+	if !ok {
+		return nil, cancelled
+	}
+	if fileName != "" {
+		if err := saveOrderParameters(fileName, service, answers); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Saved parameters to '%s'\n", fileName)
+	}
+	if !confirm("Submit order?") {
+		return nil, cancelled
+	}
+	return answers, nil
+}
+
+// Asks for the value of the parameter defined by 'd' until a valid one is entered. Returns
+// nil for an optional parameter left empty, and false if stdin was closed.
+func promptParameter(d *srv.ParameterDefTResponseBody, given map[string]string) (*string, bool) {
+	var props []string
+	if d.Type != nil {
+		props = append(props, *d.Type)
+	}
+	if d.Unit != nil {
+		props = append(props, "unit: "+*d.Unit)
+	}
+	optional := d.Optional != nil && *d.Optional
+	if optional {
+		props = append(props, "optional")
+	}
+	fmt.Fprintf(os.Stderr, "\n%s", *d.Name)
+	if len(props) > 0 {
+		fmt.Fprintf(os.Stderr, " (%s)", strings.Join(props, ", "))
+	}
+	if d.Description != nil && *d.Description != "" {
+		fmt.Fprintf(os.Stderr, " - %s", strings.TrimSpace(*d.Description))
+	}
+	fmt.Fprintln(os.Stderr)
+	for i, o := range d.Options {
+		if o.Value == nil {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "  %d) %s", i+1, *o.Value)
+		if o.Description != nil && *o.Description != "" {
+			fmt.Fprintf(os.Stderr, " - %s", *o.Description)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+
+	proposed, hasProposed := given[*d.Name]
+	if !hasProposed && d.Default != nil {
+		proposed, hasProposed = *d.Default, true
+	}
+	question := *d.Name + ": "
+	if hasProposed {
+		question = fmt.Sprintf("%s [%s]: ", *d.Name, proposed)
+	}
+	for {
+		answer, ok := prompt(question)
+		if !ok {
+			return nil, false
+		}
+		if answer == "" {
+			if hasProposed {
+				return &proposed, true
+			} else if optional {
+				return nil, true
+			}
+			fmt.Fprintf(os.Stderr, "  '%s' is required\n", *d.Name)
+			continue
+		}
+		answer = resolveOption(d, answer)
+		if problem := checkParameterValue(d, answer); problem != "" {
+			fmt.Fprintf(os.Stderr, "  '%s' %s\n", *d.Name, problem)
+			continue
+		}
+		return &answer, true
+	}
+}
+
+// Returns the value of the option of 'd' which 'answer' selects. Answers matching an
+// option's value are taken as they are, so only other ones select an option by its number.
+func resolveOption(d *srv.ParameterDefTResponseBody, answer string) string {
+	for _, o := range d.Options {
+		if o.Value != nil && *o.Value == answer {
+			return answer
+		}
+	}
+	if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= len(d.Options) && d.Options[i-1].Value != nil {
+		return *d.Options[i-1].Value
+	}
+	return answer
+}
+
+// Saves 'params' as parameter file 'fileName', which is written as JSON if it ends with '.json'
+func saveOrderParameters(fileName string, service *srv.ReadResponseBody, params []*api.ParameterT) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("while creating parameter file '%s' - %w", fileName, err)
+	}
+	defer file.Close()
+	if err = writeOrderTemplate(file, service, params, strings.HasSuffix(fileName, ".json")); err != nil {
+		return fmt.Errorf("while writing parameter file '%s' - %w", fileName, err)
+	}
+	return nil
+}
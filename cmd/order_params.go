@@ -0,0 +1,375 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "github.com/reinventingscience/ivcap-core-api/http/order"
+	srv "github.com/reinventingscience/ivcap-core-api/http/service"
+
+	sdk "github.com/reinventingscience/ivcap-cli/pkg"
+	a "github.com/reinventingscience/ivcap-cli/pkg/adapter"
+)
+
+// Prefix of parameter values naming a local file, which is uploaded as artifact
+// and replaced by the artifact's URN before the order is created
+const ARTIFACT_FILE_PREFIX = "@file:"
+
+var (
+	orderParamFile string
+	orderTemplate  bool
+)
+
+// Returns the order parameters read from the '--file' parameter file, if any, followed
+// by the 'paramName=value' arguments in 'args'. Arguments override parameters of the
+// same name in the file.
+func getOrderParameters(args []string) ([]*api.ParameterT, error) {
+	var params []*api.ParameterT
+	index := map[string]int{}
+	set := func(name string, value string) {
+		if i, ok := index[name]; ok {
+			params[i].Value = &value
+		} else {
+			index[name] = len(params)
+			params = append(params, &api.ParameterT{Name: &name, Value: &value})
+		}
+	}
+
+	if orderParamFile != "" {
+		fileParams, err := readOrderParameterFile(orderParamFile)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(fileParams))
+		for name := range fileParams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			set(name, fileParams[name])
+		}
+	}
+	for _, ps := range args {
+		pa := strings.SplitN(ps, "=", 2)
+		if len(pa) != 2 {
+			return nil, newExitError(EXIT_USAGE, "cannot parse parameter argument '%s'", ps)
+		}
+		set(pa[0], pa[1])
+	}
+	return params, nil
+}
+
+// Reads a parameter file mapping parameter names to values. Parameters without
+// a value are skipped, as they are in files created with '--template'.
+func readOrderParameterFile(fileName string) (map[string]string, error) {
+	pyld, err := payloadFromFile(fileName, inputFormat)
+	if err != nil {
+		return nil, newExitError(inputFileExitCode(err), "while reading parameter file '%s' - %w", fileName, err)
+	}
+	obj, err := pyld.AsObject()
+	if err != nil {
+		return nil, newExitError(EXIT_USAGE, "while parsing parameter file '%s' - %w", fileName, err)
+	}
+	params := make(map[string]string, len(obj))
+	for name, v := range obj {
+		switch value := v.(type) {
+		case nil:
+			continue
+		case string:
+			params[name] = value
+		case float64:
+			params[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			params[name] = strconv.FormatBool(value)
+		default:
+			return nil, newExitError(EXIT_USAGE, "value of parameter '%s' in '%s' needs to be a string, number or boolean", name, fileName)
+		}
+	}
+	return params, nil
+}
+
+// Prints a parameter file for 'service' listing all its parameters, with their values
+// taken from 'params', or otherwise the parameter's default.
+func printOrderTemplate(service *srv.ReadResponseBody, params []*api.ParameterT) error {
+	return writeOrderTemplate(os.Stdout, service, params, outputFormat == "json")
+}
+
+// Writes the parameter file printed by 'printOrderTemplate' to 'w', as JSON if 'asJSON' is set
+func writeOrderTemplate(w io.Writer, service *srv.ReadResponseBody, params []*api.ParameterT, asJSON bool) error {
+	values := map[string]string{}
+	for _, p := range params {
+		values[*p.Name] = *p.Value
+	}
+	template := func(p *srv.ParameterDefTResponseBody) (string, bool) {
+		if v, ok := values[*p.Name]; ok {
+			return v, true
+		}
+		if p.Default != nil {
+			return *p.Default, true
+		}
+		return "", false
+	}
+
+	if asJSON {
+		obj := map[string]interface{}{}
+		for _, p := range service.Parameters {
+			if p.Name == nil || (p.Constant != nil && *p.Constant) {
+				continue
+			}
+			if v, ok := template(p); ok {
+				obj[*p.Name] = v
+			} else {
+				obj[*p.Name] = nil
+			}
+		}
+		return fprintValue(w, obj, false)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Parameters for service '%s' (%s)\n", safeString(service.Name), safeString(service.ID))
+	fmt.Fprintf(&sb, "#\n# Use with: ivcap order create -f <this file> %s\n", safeString(service.ID))
+	for _, p := range service.Parameters {
+		if p.Name == nil || (p.Constant != nil && *p.Constant) {
+			continue
+		}
+		sb.WriteString("\n")
+		var props []string
+		if p.Type != nil {
+			props = append(props, *p.Type)
+		}
+		if p.Unit != nil {
+			props = append(props, "unit: "+*p.Unit)
+		}
+		if p.Optional != nil && *p.Optional {
+			props = append(props, "optional")
+		}
+		fmt.Fprintf(&sb, "# %s", *p.Name)
+		if len(props) > 0 {
+			fmt.Fprintf(&sb, " (%s)", strings.Join(props, ", "))
+		}
+		if p.Description != nil && *p.Description != "" {
+			fmt.Fprintf(&sb, " - %s", strings.ReplaceAll(*p.Description, "\n", "\n#   "))
+		}
+		sb.WriteString("\n")
+		for _, o := range p.Options {
+			if o.Value != nil {
+				fmt.Fprintf(&sb, "#   %s", *o.Value)
+				if o.Description != nil {
+					fmt.Fprintf(&sb, " - %s", *o.Description)
+				}
+				sb.WriteString("\n")
+			}
+		}
+		if v, ok := template(p); ok {
+			fmt.Fprintf(&sb, "%s: %s\n", *p.Name, strconv.Quote(v))
+		} else {
+			fmt.Fprintf(&sb, "%s:\n", *p.Name)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Checks 'params' against the parameter definitions of 'service' and returns them
+// together with the defaults of all parameters not set. All problems found are
+// reported together in the returned error.
+func validateOrderParameters(service *srv.ReadResponseBody, params []*api.ParameterT) ([]*api.ParameterT, error) {
+	defs := map[string]*srv.ParameterDefTResponseBody{}
+	for _, d := range service.Parameters {
+		if d.Name != nil {
+			defs[*d.Name] = d
+		}
+	}
+	var problems []string
+	values := map[string]bool{}
+	for _, p := range params {
+		values[*p.Name] = true
+		d, ok := defs[*p.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("parameter '%s' is not defined by the requested service", *p.Name))
+			continue
+		}
+		if d.Constant != nil && *d.Constant {
+			problems = append(problems, fmt.Sprintf("parameter '%s' is constant and cannot be set", *p.Name))
+			continue
+		}
+		if problem := checkParameterValue(d, *p.Value); problem != "" {
+			problems = append(problems, fmt.Sprintf("parameter '%s' %s", *p.Name, problem))
+		}
+	}
+	for _, d := range service.Parameters {
+		if d.Name == nil || values[*d.Name] || (d.Constant != nil && *d.Constant) {
+			continue
+		}
+		if d.Default != nil {
+			params = append(params, &api.ParameterT{Name: d.Name, Value: d.Default})
+		} else if d.Optional == nil || !*d.Optional {
+			problems = append(problems, fmt.Sprintf("parameter '%s' is required", *d.Name))
+		}
+	}
+	if len(problems) == 1 {
+		return nil, newExitError(EXIT_USAGE, "%s", problems[0])
+	} else if len(problems) > 1 {
+		return nil, newExitError(EXIT_USAGE, "%d invalid parameters:\n  - %s", len(problems), strings.Join(problems, "\n  - "))
+	}
+	return params, nil
+}
+
+// Returns why 'value' isn't valid for the parameter defined by 'def', or "" if it is
+func checkParameterValue(def *srv.ParameterDefTResponseBody, value string) string {
+	if fileName, ok := parameterFile(def, value); ok {
+		if info, err := os.Stat(fileName); err != nil || !info.Mode().IsRegular() {
+			return fmt.Sprintf("refers to '%s', which is not a readable file", fileName)
+		}
+		return ""
+	}
+	if len(def.Options) > 0 {
+		var options []string
+		for _, o := range def.Options {
+			if o.Value == nil {
+				continue
+			}
+			if *o.Value == value {
+				return ""
+			}
+			options = append(options, *o.Value)
+		}
+		return fmt.Sprintf("needs to be one of '%s', but is '%s'", strings.Join(options, "', '"), value)
+	}
+	var typ string
+	if def.Type != nil {
+		typ = strings.ToLower(*def.Type)
+	}
+	switch typ {
+	case "number", "float", "double":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Sprintf("needs to be a number, but is '%s'", value)
+		}
+	case "int", "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Sprintf("needs to be an integer, but is '%s'", value)
+		}
+	case "bool", "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Sprintf("needs to be 'true' or 'false', but is '%s'", value)
+		}
+	case "artifact":
+		if !strings.HasPrefix(value, "urn:") {
+			return fmt.Sprintf("needs to be an artifact URN or a local file, but is '%s'", value)
+		}
+	}
+	return ""
+}
+
+// Returns the local file named by parameter 'value', either with the '@file:' prefix, or
+// for artifact parameters, as path to an existing file. 'def' may be nil if not known.
+func parameterFile(def *srv.ParameterDefTResponseBody, value string) (string, bool) {
+	if strings.HasPrefix(value, ARTIFACT_FILE_PREFIX) {
+		return strings.TrimPrefix(value, ARTIFACT_FILE_PREFIX), true
+	}
+	if def == nil || def.Type == nil || strings.ToLower(*def.Type) != "artifact" || strings.HasPrefix(value, "urn:") {
+		return "", false
+	}
+	if info, err := os.Stat(value); err == nil && info.Mode().IsRegular() {
+		return value, true
+	}
+	return "", false
+}
+
+// Uploads all local files named by 'params' as artifacts and replaces the parameter
+// values with the artifact URNs. Files named more than once are uploaded only once.
+// 'service' may be nil if the parameter definitions are not known.
+func uploadParameterFiles(ctxt context.Context, service *srv.ReadResponseBody, params []*api.ParameterT) error {
+	defs := map[string]*srv.ParameterDefTResponseBody{}
+	if service != nil {
+		for _, d := range service.Parameters {
+			if d.Name != nil {
+				defs[*d.Name] = d
+			}
+		}
+	}
+	var adapter *a.Adapter
+	uploaded := map[string]string{}
+	for _, p := range params {
+		fileName, ok := parameterFile(defs[*p.Name], *p.Value)
+		if !ok {
+			continue
+		}
+		if artifactID, ok := uploaded[fileName]; ok {
+			p.Value = &artifactID
+			continue
+		}
+		if adapter == nil {
+			adapter = CreateAdapterWithTimeout(true, DEF_TRANSFER_TIMEOUT)
+		}
+		artifactID, err := uploadParameterFile(ctxt, fileName, adapter)
+		if err != nil {
+			return fmt.Errorf("while uploading '%s' for parameter '%s' - %w", fileName, *p.Name, err)
+		}
+		uploaded[fileName] = artifactID
+		p.Value = &artifactID
+	}
+	return nil
+}
+
+// Uploads 'fileName' as a new artifact, showing its progress on stderr unless '--silent'
+func uploadParameterFile(ctxt context.Context, fileName string, adapter *a.Adapter) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", newExitError(inputFileExitCode(err), "while opening '%s' - %v", fileName, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	format, err := getFileContentType(file)
+	if err != nil {
+		return "", fmt.Errorf("while checking content type of file '%s' - %w", fileName, err)
+	}
+	digest, err := getChecksumHash()
+	if err != nil {
+		return "", err
+	}
+	var reader io.Reader = bufio.NewReader(file)
+	if !silent {
+		reader = io.TeeReader(reader, sdk.GetProgressBar(filepath.Base(fileName), info.Size()))
+	}
+	req := &sdk.CreateArtifactRequest{
+		Name: filepath.Base(fileName),
+		Size: info.Size(),
+	}
+	artifactID, _, err := createFileArtifact(ctxt, reader, fileName, format, info.Size(), req,
+		GetActiveContext().URL, digest, 1, true, adapter)
+	if !silent {
+		fmt.Fprintf(os.Stderr, "\n") // To move past progress bar
+	}
+	if err != nil {
+		return "", err
+	}
+	if !silent {
+		fmt.Fprintf(os.Stderr, "Uploaded '%s' as artifact '%s'\n", fileName, artifactID)
+	}
+	return artifactID, nil
+}
@@ -15,12 +15,16 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"time"
@@ -61,6 +65,11 @@ var (
 	accessTokenProvided bool
 	timeout             int
 	debug               bool
+	retries             int
+	retryMaxWait        int
+	recordHar           string
+	recordDir           string
+	replayDir           string
 
 	// common, but not global flags
 	recordID     string
@@ -76,6 +85,8 @@ type Config struct {
 	Version       string    `yaml:"version"`
 	ActiveContext string    `yaml:"active-context"`
 	Contexts      []Context `yaml:"contexts"`
+	// Checked before the built-in rules when detecting the content type of uploaded files
+	ContentTypes []ContentTypeRule `yaml:"content-types,omitempty"`
 }
 
 type Context struct {
@@ -86,6 +97,14 @@ type Context struct {
 	ProviderID string `yaml:"provider-id"`
 	Host       string `yaml:"host"` // set Host header if necessary
 
+	// Transport Configuration
+	CAFile       string `yaml:"ca-file,omitempty"`
+	ClientCert   string `yaml:"client-cert,omitempty"`
+	ClientKey    string `yaml:"client-key,omitempty"`
+	Proxy        string `yaml:"proxy,omitempty"`
+	Insecure     bool   `yaml:"insecure-skip-verify,omitempty"`
+	DisableHTTP2 bool   `yaml:"disable-http2,omitempty"`
+
 	// User Information
 	AccountName     string `yaml:"account-name"`
 	AccountNickName string `yaml:"account-nickname"`
@@ -113,12 +132,31 @@ API exposed by a specific IVCAP deployment.`,
 func Execute(version string) {
 	rootCmd.Version = version
 	rootCmd.SilenceUsage = true
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	// errors are reported by us to map them to exit codes
+	rootCmd.SilenceErrors = true
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &ExitError{EXIT_USAGE, err}
+	})
+	markArgErrorsAsUsage(rootCmd)
+	// Cancel all in-flight requests on Ctrl-C. A second Ctrl-C terminates immediately.
+	ctxt, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctxt.Done()
+		stop()
+	}()
+	if err := rootCmd.ExecuteContext(ctxt); err != nil {
+		code := exitCodeFor(err)
+		if strings.HasPrefix(err.Error(), "unknown command") {
+			// cobra doesn't provide a dedicated error type for this
+			code = EXIT_USAGE
+		}
+		reportError(code, err)
+		exit(code)
 	}
 	if err := saveHistory(); err != nil {
-		os.Exit(1)
+		exit(EXIT_ERROR)
 	}
+	exit(EXIT_SUCCESS)
 }
 
 func init() {
@@ -129,6 +167,16 @@ func init() {
 		fmt.Sprintf("Access token to use for authentication with API server [%s]", ACCESS_TOKEN_ENV))
 	rootCmd.PersistentFlags().IntVar(&timeout, "timeout", 10, "Max. number of seconds to wait for completion")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Set logging level to DEBUG")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", adpt.DEF_RETRY_MAX_ATTEMPTS-1,
+		"Max. number of retries for failed requests which can be safely repeated")
+	rootCmd.PersistentFlags().IntVar(&retryMaxWait, "retry-max-wait", int(adpt.DEF_RETRY_MAX_BACKOFF/time.Second),
+		"Max. number of seconds to wait between retries")
+	rootCmd.PersistentFlags().StringVar(&recordHar, "record-har", "",
+		"Record all API requests and responses into this HAR file (access tokens are redacted)")
+	rootCmd.PersistentFlags().StringVar(&recordDir, "record", "",
+		"Record all API replies into this cassette directory")
+	rootCmd.PersistentFlags().StringVar(&replayDir, "replay", "",
+		"Serve all API replies from this cassette directory without accessing the network")
 	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "", "Set format for displaying output [json, yaml]")
 	rootCmd.PersistentFlags().BoolVar(&silent, "silent", false, "Do not show any progress information")
 }
@@ -169,15 +217,18 @@ func CreateAdapter(requiresAuth bool) (adapter *adpt.Adapter) {
 //     the URL defined in ActiveContext is automatically prefixed.
 //   - If the ActiveContext defines a `Host` parameter, it is also added as a
 //     `Host` HTTP header.
+//   - If `requiresAuth` is set and the server rejects the access token, it is
+//     refreshed once via the refresh token of the ActiveContext and the request replayed.
 func CreateAdapterWithTimeout(requiresAuth bool, timeoutSec int) (adapter *adpt.Adapter) {
 	ctxt := GetActiveContext() // will always return with a context
 
-	if requiresAuth {
+	// recorded replies don't depend on credentials
+	if requiresAuth && replayDir == "" {
 		if accessToken == "" {
 			accessToken = getAccessToken(true)
 		}
 		if accessToken == "" {
-			cobra.CheckErr(
+			checkErr(EXIT_UNAUTHORIZED,
 				fmt.Sprintf("Adapter requires auth token. Set with '--access-token' or env '%s'", ACCESS_TOKEN_ENV))
 		}
 	}
@@ -189,9 +240,18 @@ func CreateAdapterWithTimeout(requiresAuth bool, timeoutSec int) (adapter *adpt.
 	}
 	logger.Debug("Adapter config", log.String("url", url))
 
-	adp, err := NewAdapter(url, accessToken, timeoutSec, headers)
+	transport, err := getTransport(ctxt)
+	if err != nil {
+		checkErr(EXIT_USAGE, fmt.Sprintf("cannot configure connection for '%s' - %s", url, err))
+	}
+
+	var refresher adpt.TokenRefresher
+	if requiresAuth {
+		refresher = tokenRefresher
+	}
+	adp, err := NewAdapter(url, accessToken, timeoutSec, headers, transport, refresher)
 	if adp == nil || err != nil {
-		cobra.CheckErr(fmt.Sprintf("cannot create adapter for '%s' - %s", url, err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("cannot create adapter for '%s' - %s", url, err))
 	}
 	return adp
 }
@@ -209,7 +269,7 @@ func GetContext(name string, defaultToActiveContext bool) (ctxt *Context) {
 	}
 	if name == "" {
 		// no context or active context is found
-		cobra.CheckErr("Cannot find suitable context. Use '--context' or set default via 'context' command")
+		checkErr(EXIT_USAGE, "Cannot find suitable context. Use '--context' or set default via 'context' command")
 		return
 	}
 
@@ -221,7 +281,7 @@ func GetContext(name string, defaultToActiveContext bool) (ctxt *Context) {
 	}
 
 	if ctxt == nil {
-		cobra.CheckErr(fmt.Sprintf("unknown context '%s' in config '%s'", name, configFile))
+		checkErr(EXIT_USAGE, fmt.Sprintf("unknown context '%s' in config '%s'", name, configFile))
 	}
 	return
 }
@@ -237,7 +297,7 @@ func SetContext(ctxt *Context, failIfNotExist bool) {
 		}
 	}
 	if failIfNotExist {
-		cobra.CheckErr(fmt.Sprintf("attempting to set/update non existing context '%s'", ctxt.Name))
+		checkErr(EXIT_ERROR, fmt.Sprintf("attempting to set/update non existing context '%s'", ctxt.Name))
 	} else {
 		config.Contexts = append(config.Contexts, *ctxt)
 		if len(config.Contexts) == 1 {
@@ -260,15 +320,15 @@ func ReadConfigFile(createIfNoConfig bool) (config *Config, configFile string) {
 				}
 				return
 			} else {
-				cobra.CheckErr("Config file does not exist. Please create the config file with the context command.")
+				checkErr(EXIT_USAGE, "Config file does not exist. Please create the config file with the context command.")
 			}
 		} else {
-			cobra.CheckErr(fmt.Sprintf("Cannot read config file %s - %v", configFile, err))
+			checkErr(EXIT_ERROR, fmt.Sprintf("Cannot read config file %s - %v", configFile, err))
 		}
 	}
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		cobra.CheckErr(fmt.Sprintf("problems parsing config file %s - %v", configFile, err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("problems parsing config file %s - %v", configFile, err))
 		return
 	}
 	config = &cfg
@@ -278,21 +338,21 @@ func ReadConfigFile(createIfNoConfig bool) (config *Config, configFile string) {
 func WriteConfigFile(config *Config) {
 	b, err := yaml.Marshal(config)
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("cannot marshall content of config file - %v", err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("cannot marshall content of config file - %v", err))
 		return
 	}
 
 	configFile := GetConfigFilePath()
 
 	if err = ioutil.WriteFile(configFile, b, fs.FileMode(0600)); err != nil {
-		cobra.CheckErr(fmt.Sprintf("cannot write to config file %s - %v", configFile, err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("cannot write to config file %s - %v", configFile, err))
 	}
 }
 
 func GetConfigDir(createIfNoExist bool) (configDir string) {
 	userConfigDir, err := os.UserConfigDir()
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("Cannot find the user configuration directory - %v", err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("Cannot find the user configuration directory - %v", err))
 		return
 	}
 	configDir = userConfigDir + string(os.PathSeparator) + CONFIG_FILE_DIR
@@ -300,7 +360,7 @@ func GetConfigDir(createIfNoExist bool) (configDir string) {
 	if createIfNoExist {
 		err = os.MkdirAll(configDir, 0755)
 		if err != nil && !os.IsExist(err) {
-			cobra.CheckErr(fmt.Sprintf("Could not create configuration directory %s - %v", configDir, err))
+			checkErr(EXIT_ERROR, fmt.Sprintf("Could not create configuration directory %s - %v", configDir, err))
 			return
 		}
 	}
@@ -371,7 +431,7 @@ func GetHistory(token string) (value string) {
 	var hm map[string]string
 	if err == nil {
 		if err := yaml.Unmarshal(data, &hm); err != nil {
-			cobra.CheckErr(fmt.Sprintf("problems parsing history file %s - %v", path, err))
+			checkErr(EXIT_ERROR, fmt.Sprintf("problems parsing history file %s - %v", path, err))
 			return
 		}
 		if val, ok := hm[token]; ok {
@@ -380,12 +440,12 @@ func GetHistory(token string) (value string) {
 	} else {
 		// fail "normally" if file doesn't exist
 		if _, ok := err.(*os.PathError); !ok {
-			cobra.CheckErr("Error reading history file. Use full names instead.")
+			checkErr(EXIT_ERROR, "Error reading history file. Use full names instead.")
 			return
 		}
 	}
 	if vp == nil {
-		cobra.CheckErr(fmt.Sprintf("Unknown history '%s'.", token))
+		checkErr(EXIT_USAGE, fmt.Sprintf("Unknown history '%s'.", token))
 		return
 	}
 	return *vp
@@ -398,14 +458,14 @@ func saveHistory() (err error) {
 
 	b, err := yaml.Marshal(history)
 	if err != nil {
-		cobra.CheckErr(fmt.Sprintf("cannot marshall history - %v", err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("cannot marshall history - %v", err))
 		return
 	}
 
 	path := makeConfigFilePath(HISTORY_FILE_NAME)
 
 	if err = ioutil.WriteFile(path, b, fs.FileMode(0600)); err != nil {
-		cobra.CheckErr(fmt.Sprintf("cannot write history to file %s - %v", path, err))
+		checkErr(EXIT_ERROR, fmt.Sprintf("cannot write history to file %s - %v", path, err))
 	}
 	return
 }
@@ -421,17 +481,105 @@ func NewAdapter(
 	accessToken string,
 	timeoutSec int,
 	headers *map[string]string,
+	transport http.RoundTripper,
+	refresher adpt.TokenRefresher,
 ) (*adpt.Adapter, error) {
 	adapter := adpt.RestAdapter(adpt.ConnectionCtxt{
 		URL: url, AccessToken: accessToken, TimeoutSec: timeoutSec, Headers: headers,
+		Retry: getRetryPolicy(), Transport: transport, TokenRefresher: refresher,
 	})
 	return &adapter, nil
 }
 
-func NewTimeoutContext() (ctxt context.Context) {
+// All adapters share the same transport to reuse connections
+var transport http.RoundTripper
+
+// Returns the transport shared by all adapters. It is built from 'ctxt' on
+// the first call and cached for the rest of the process, so it is only valid
+// for the context returned by GetActiveContext(). Use NewTransport directly
+// to check the settings of any other context.
+func getTransport(ctxt *Context) (http.RoundTripper, error) {
+	if transport != nil {
+		return transport, nil
+	}
+	var t http.RoundTripper
+	if replayDir != "" {
+		if recordDir != "" {
+			return nil, fmt.Errorf("'--record' and '--replay' cannot be used together")
+		}
+		c, err := adpt.NewReplayingCassette(replayDir)
+		if err != nil {
+			return nil, err
+		}
+		t = c
+	} else {
+		ht, err := adpt.NewTransport(transportConfig(ctxt))
+		if err != nil {
+			return nil, err
+		}
+		t = ht
+		if recordDir != "" {
+			if t, err = adpt.NewRecordingCassette(recordDir, ht); err != nil {
+				return nil, err
+			}
+		}
+	}
+	transport = t
+	if recordHar != "" {
+		harRecorder = adpt.NewHarRecorder(t, "ivcap-cli", rootCmd.Version)
+		harRecorder.Logger = logger
+		transport = harRecorder
+	}
+	return transport, nil
+}
+
+// Returns the connection settings of 'ctxt' as expected by NewTransport
+func transportConfig(ctxt *Context) *adpt.TransportConfig {
+	return &adpt.TransportConfig{
+		CAFile:             ctxt.CAFile,
+		ClientCertFile:     ctxt.ClientCert,
+		ClientKeyFile:      ctxt.ClientKey,
+		ProxyURL:           ctxt.Proxy,
+		InsecureSkipVerify: ctxt.Insecure,
+		DisableHTTP2:       ctxt.DisableHTTP2,
+	}
+}
+
+// Set when requests are recorded with '--record-har'
+var harRecorder *adpt.HarRecorder
+
+// Saves all requests recorded so far into the file set by '--record-har'
+func saveHarRecording() error {
+	if harRecorder == nil {
+		return nil
+	}
+	logger.Debug("Saving HAR recording", log.String("file", recordHar))
+	return harRecorder.Save(recordHar)
+}
+
+// Returns the retry policy as configured by the `--retries` and
+// `--retry-max-wait` flags
+func getRetryPolicy() *adpt.RetryPolicy {
+	policy := adpt.DefaultRetryPolicy()
+	policy.MaxAttempts = retries + 1
+	policy.MaxBackoff = time.Duration(retryMaxWait) * time.Second
+	return policy
+}
+
+// Returns a context which is cancelled after `--timeout` seconds, or
+// when the user interrupts the command.
+func NewTimeoutContext() (ctxt context.Context, cancel context.CancelFunc) {
 	to := time.Now().Add(time.Duration(timeout) * time.Second)
-	ctxt, _ = context.WithDeadline(context.Background(), to)
-	return
+	return context.WithDeadline(appContext(), to)
+}
+
+// Returns the context of the executing command which is cancelled when
+// the user interrupts the command.
+func appContext() context.Context {
+	if ctxt := rootCmd.Context(); ctxt != nil {
+		return ctxt
+	}
+	return context.Background()
 }
 
 func Logger() *log.Logger {
@@ -502,6 +650,54 @@ func safeBytes(n *int64) string {
 	}
 }
 
+// Prints 'v' as JSON, or YAML if 'useYAML' is set
+func printValue(v interface{}, useYAML bool) (err error) {
+	return fprintValue(os.Stdout, v, useYAML)
+}
+
+func fprintValue(w io.Writer, v interface{}, useYAML bool) (err error) {
+	var b []byte
+	if useYAML {
+		if b, err = yaml.Marshal(v); err != nil {
+			return
+		}
+	} else {
+		if b, err = json.MarshalIndent(v, "", "  "); err != nil {
+			return
+		}
+	}
+	fmt.Fprintf(w, "%s\n", b)
+	return
+}
+
+// Asks the user to confirm 'question' on stdin. Anything but 'y' or 'yes', including
+// stdin being closed, counts as no.
+func confirm(question string) bool {
+	answer, ok := prompt(fmt.Sprintf("%s [y/N] ", question))
+	if !ok {
+		return false
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+var stdinReader *bufio.Reader
+
+// Prints 'question' on stderr and returns the line entered on stdin without
+// surrounding spaces. Returns false if stdin is closed.
+func prompt(question string) (string, bool) {
+	fmt.Fprint(os.Stderr, question)
+	if stdinReader == nil {
+		stdinReader = bufio.NewReader(os.Stdin)
+	}
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(os.Stderr)
+		return "", false
+	}
+	return strings.TrimSpace(answer), true
+}
+
 func payloadFromFile(fileName string, inputFormat string) (pyld adpt.Payload, err error) {
 	isYaml := inputFormat == "yaml" || strings.HasSuffix(fileName, ".yaml") || strings.HasSuffix(fileName, ".yml")
 	if fileName != "-" {
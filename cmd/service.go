@@ -15,7 +15,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -74,7 +73,7 @@ var (
 			if limit > 0 {
 				req.Limit = limit
 			}
-			if res, err := sdk.ListServicesRaw(context.Background(), req, CreateAdapter(true), logger); err == nil {
+			if res, err := sdk.ListServicesRaw(cmd.Context(), req, CreateAdapter(true), logger); err == nil {
 				switch outputFormat {
 				case "json":
 					a.ReplyPrinter(res, false)
@@ -103,13 +102,13 @@ var (
 
 			switch outputFormat {
 			case "json", "yaml":
-				if res, err := sdk.ReadServiceRaw(context.Background(), req, CreateAdapter(true), logger); err == nil {
+				if res, err := sdk.ReadServiceRaw(cmd.Context(), req, CreateAdapter(true), logger); err == nil {
 					a.ReplyPrinter(res, outputFormat == "yaml")
 				} else {
 					return err
 				}
 			default:
-				if service, err := sdk.ReadService(context.Background(), req, CreateAdapter(true), logger); err == nil {
+				if service, err := sdk.ReadService(cmd.Context(), req, CreateAdapter(true), logger); err == nil {
 					printService(service, false)
 				} else {
 					return err
@@ -127,11 +126,11 @@ described in a service definition file. If the service definition is provided
 through 'stdin' use '-' as the file name and also include the --format flag`,
 		Args: cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
-			ctxt := context.Background()
+			ctxt := cmd.Context()
 
 			pyld, err := payloadFromFile(serviceFile, inputFormat)
 			if err != nil {
-				cobra.CheckErr(fmt.Sprintf("While reading service file '%s' - %s", serviceFile, err))
+				checkErr(inputFileExitCode(err), fmt.Sprintf("While reading service file '%s' - %s", serviceFile, err))
 			}
 			var req api.CreateRequestBody
 			if err = pyld.AsType(&req); err != nil {
@@ -154,7 +153,7 @@ AND the --create flag is set. If the service definition is provided
 through 'stdin' use '-' as the file name and also include the --format flag `,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
-			ctxt := context.Background()
+			ctxt := cmd.Context()
 			serviceID := GetHistory(args[0])
 			// serviceFile := args[1]
 
@@ -166,7 +165,7 @@ through 'stdin' use '-' as the file name and also include the --format flag `,
 				pyld, err = a.LoadPayloadFromStdin(isYaml)
 			}
 			if err != nil {
-				cobra.CheckErr(fmt.Sprintf("While reading service file '%s' - %s", serviceFile, err))
+				checkErr(inputFileExitCode(err), fmt.Sprintf("While reading service file '%s' - %s", serviceFile, err))
 			}
 
 			var req api.UpdateRequestBody
@@ -264,7 +263,7 @@ func GetServiceNameForId(serviceID *string) string {
 	req := &sdk.ReadServiceRequest{
 		Id: *serviceID,
 	}
-	if resp, err := sdk.ReadService(context.Background(), req, CreateAdapter(true), logger); err == nil {
+	if resp, err := sdk.ReadService(appContext(), req, CreateAdapter(true), logger); err == nil {
 		return *resp.Name
 	} else {
 		return *serviceID
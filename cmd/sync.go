@@ -0,0 +1,356 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	sdk "github.com/reinventingscience/ivcap-cli/pkg"
+	a "github.com/reinventingscience/ivcap-cli/pkg/adapter"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	log "go.uber.org/zap"
+)
+
+func init() {
+	artifactCmd.AddCommand(syncArtifactsCmd)
+	syncArtifactsCmd.Flags().BoolVar(&syncDownload, "download", false, "Also download files which only exist in the collection")
+	syncArtifactsCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show what would be done")
+	syncArtifactsCmd.Flags().IntVar(&uploadJobs, "jobs", DEF_UPLOAD_JOBS, "Max. number of files transferred concurrently")
+	syncArtifactsCmd.Flags().StringVarP(&contentType, "content-type", "t", "", "Content type of uploaded files [detected]")
+	syncArtifactsCmd.Flags().Int64Var(&chunkSize, "chunk-size", DEF_CHUNK_SIZE, "Chunk size for splitting large files")
+	syncArtifactsCmd.Flags().StringVar(&checksum, "checksum", sdk.CHECKSUM_SHA256, "Checksum algorithm to verify uploaded content (sha256, md5)")
+}
+
+const (
+	SYNC_UPLOAD      = "upload"
+	SYNC_UPDATE      = "update"
+	SYNC_DOWNLOAD    = "download"
+	SYNC_UNCHANGED   = "unchanged"
+	SYNC_REMOTE_ONLY = "remote-only"
+)
+
+type syncAction struct {
+	Action string `json:"action" yaml:"action"`
+	Path   string `json:"path" yaml:"path"`
+	// Artifact holding the file, which is the new one after an upload or update
+	ArtifactID string `json:"artifact-id,omitempty" yaml:"artifact-id,omitempty"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+
+	size   int64
+	remote *remoteFile // artifact currently holding the file, if any
+}
+
+type remoteFile struct {
+	artifactID string
+	recordID   string // of the path metadata record
+}
+
+var (
+	syncDownload bool
+	dryRun       bool
+
+	syncArtifactsCmd = &cobra.Command{
+		Use:   "sync [flags] dir collection",
+		Short: "Upload new or changed files in a directory to a collection",
+		Long: `Compares the files in a directory with the artifacts uploaded from it to
+a collection (see 'artifact create --recursive'), using their size and recorded
+checksum. Files which are new or changed are uploaded as new artifacts, which
+replace the earlier ones in the collection. With '--download', files which only
+exist in the collection are downloaded into the directory.`,
+		Args: cobra.ExactArgs(2),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, collection := args[0], args[1]
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				return newExitError(EXIT_USAGE, "'%s' is not a directory", dir)
+			}
+			if checksum == sdk.CHECKSUM_NONE {
+				return newExitError(EXIT_USAGE, "sync needs checksums to detect changed files")
+			}
+			if err := checkChunkSize(cmd); err != nil {
+				return err
+			}
+			if _, err := getChecksumHash(); err != nil {
+				return err
+			}
+			ctxt := cmd.Context()
+			adapter := CreateAdapterWithTimeout(true, DEF_TRANSFER_TIMEOUT)
+			actions, err := planSync(ctxt, dir, collection, adapter)
+			if err != nil {
+				return err
+			}
+			if !dryRun {
+				artifactCollection = collection
+				runSync(ctxt, dir, actions, adapter)
+			}
+			return printSyncActions(actions)
+		},
+	}
+)
+
+// Compares the files in 'dir' with the artifacts in 'collection' and returns what needs to be done for each
+func planSync(ctxt context.Context, dir string, collection string, adapter *a.Adapter) ([]*syncAction, error) {
+	files, _, err := listDirFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := listCollectionFiles(ctxt, collection, adapter)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []*syncAction
+	for _, f := range files {
+		if strings.HasSuffix(f.Path, sdk.DOWNLOAD_PART_SUFFIX) || strings.HasSuffix(f.Path, sdk.DOWNLOAD_RANGES_SUFFIX) ||
+			strings.HasSuffix(f.Path, sdk.DOWNLOAD_INFO_SUFFIX) {
+			continue // unfinished download
+		}
+		act := &syncAction{Action: SYNC_UPLOAD, Path: f.Path, size: f.Size}
+		if r, ok := remote[f.Path]; ok {
+			delete(remote, f.Path)
+			act.remote = r
+			act.ArtifactID = r.artifactID
+			if same, err := sameContent(ctxt, filepath.Join(dir, filepath.FromSlash(f.Path)), f.Size, r.artifactID, adapter); err != nil {
+				return nil, err
+			} else if same {
+				act.Action = SYNC_UNCHANGED
+			} else {
+				act.Action = SYNC_UPDATE
+			}
+		}
+		actions = append(actions, act)
+	}
+	for path, r := range remote {
+		act := &syncAction{Action: SYNC_REMOTE_ONLY, Path: path, ArtifactID: r.artifactID, remote: r}
+		if syncDownload {
+			act.Action = SYNC_DOWNLOAD
+		}
+		actions = append(actions, act)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Path < actions[j].Path })
+	return actions, nil
+}
+
+// Returns the artifacts in 'collection' by the relative path recorded for them
+func listCollectionFiles(ctxt context.Context, collection string, adapter *a.Adapter) (map[string]*remoteFile, error) {
+	members, err := listCollectionArtifacts(ctxt, collection, adapter)
+	if err != nil {
+		return nil, fmt.Errorf("while listing content of collection '%s' - %w", collection, err)
+	}
+	files := map[string]*remoteFile{}
+	for _, item := range members.Artifacts {
+		records, err := sdk.ListAllMetadata(ctxt, *item.ID, sdk.ARTIFACT_PATH_SCHEMA, adapter, logger)
+		if err != nil {
+			return nil, fmt.Errorf("while getting path of artifact '%s' - %w", *item.ID, err)
+		}
+		// artifacts may belong to several collections, so prefer the path recorded for this one
+		var path string
+		var file *remoteFile
+		for _, r := range records {
+			var p sdk.ArtifactPath
+			if r.RecordID == nil || asType(r.Aspect, &p) != nil || p.Path == "" {
+				continue
+			}
+			if !isLocalPath(p.Path) {
+				// would otherwise be downloaded to outside of the synced directory
+				logger.Warn("ignoring artifact with invalid path", log.String("artifactID", *item.ID), log.String("path", p.Path))
+				continue
+			}
+			if file == nil || p.Collection == collection {
+				path, file = p.Path, &remoteFile{artifactID: *item.ID, recordID: *r.RecordID}
+			}
+		}
+		if file != nil {
+			files[path] = file
+		}
+	}
+	return files, nil
+}
+
+// Returns true if 'path' (using '/' as separator) is relative and stays within the directory it is relative to
+func isLocalPath(path string) bool {
+	p := filepath.FromSlash(path)
+	if p == "" || filepath.IsAbs(p) || filepath.VolumeName(p) != "" || strings.HasPrefix(p, string(filepath.Separator)) {
+		return false
+	}
+	p = filepath.Clean(p)
+	return p != "." && p != ".." && !strings.HasPrefix(p, ".."+string(filepath.Separator))
+}
+
+// Returns true if the content of the file matches the size and checksum recorded for 'artifactID'
+func sameContent(ctxt context.Context, fileName string, size int64, artifactID string, adapter *a.Adapter) (bool, error) {
+	records, err := sdk.ListAllMetadata(ctxt, artifactID, sdk.ARTIFACT_CHECKSUM_SCHEMA, adapter, logger)
+	if err != nil {
+		return false, fmt.Errorf("while getting checksum of artifact '%s' - %w", artifactID, err)
+	}
+	if len(records) == 0 {
+		logger.Debug("no checksum recorded", log.String("artifactID", artifactID))
+		return false, nil
+	}
+	var cs sdk.ArtifactChecksum
+	if err = asType(records[len(records)-1].Aspect, &cs); err != nil || cs.Size != size {
+		return false, nil
+	}
+	h, err := sdk.NewChecksumHash(cs.Algorithm)
+	if err != nil {
+		return false, nil
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err = io.Copy(h, file); err != nil {
+		return false, fmt.Errorf("while calculating checksum of '%s' - %w", fileName, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)) == cs.Digest, nil
+}
+
+// Executes all upload, update and download actions, using up to 'uploadJobs' workers
+func runSync(ctxt context.Context, dir string, actions []*syncAction, adapter *a.Adapter) {
+	deployment := GetActiveContext().URL
+	var total int64
+	for _, act := range actions {
+		if act.Action == SYNC_UPLOAD || act.Action == SYNC_UPDATE {
+			total += act.size
+		}
+	}
+	var bar io.Writer
+	if !silent && total > 0 {
+		bar = sdk.GetProgressBar("... uploading files", total)
+	}
+	jobs := make(chan *syncAction)
+	var wg sync.WaitGroup
+	workers := uploadJobs
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for act := range jobs {
+				if err := runSyncAction(ctxt, dir, act, deployment, bar, adapter); err != nil {
+					act.Error = err.Error()
+					logger.Debug("sync failed", log.String("path", act.Path), log.Error(err))
+				}
+			}
+		}()
+	}
+	for _, act := range actions {
+		jobs <- act
+	}
+	close(jobs)
+	wg.Wait()
+	if bar != nil {
+		fmt.Fprintf(os.Stderr, "\n") // To move past progress bar
+	}
+}
+
+func runSyncAction(ctxt context.Context, dir string, act *syncAction, deployment string, bar io.Writer, adapter *a.Adapter) error {
+	switch act.Action {
+	case SYNC_UPLOAD, SYNC_UPDATE:
+		artifactID, err := createDirArtifact(ctxt, dir, &manifestEntry{Path: act.Path, Size: act.size}, deployment, bar, adapter)
+		if err != nil {
+			return err
+		}
+		act.ArtifactID = artifactID
+		if act.remote != nil {
+			// only once the new artifact holds the path, so that a failed upload leaves the old one in place
+			if _, err := sdk.RevokeMetadata(ctxt, act.remote.recordID, adapter, logger); err != nil {
+				return fmt.Errorf("while revoking path of artifact '%s' - %w", act.remote.artifactID, err)
+			}
+			// the new artifact replaces the old one in the collection
+			var notFound *a.ResourceNotFoundError
+			if _, err := sdk.RemoveArtifactToCollection(ctxt, act.remote.artifactID, artifactCollection, adapter, logger); err != nil && !errors.As(err, &notFound) {
+				return fmt.Errorf("while removing artifact '%s' from collection - %w", act.remote.artifactID, err)
+			}
+		}
+	case SYNC_DOWNLOAD:
+		artifact, err := sdk.ReadArtifact(ctxt, &sdk.ReadArtifactRequest{Id: act.ArtifactID}, adapter, logger)
+		if err != nil {
+			return err
+		}
+		if artifact.Data == nil || artifact.Data.Self == nil {
+			return fmt.Errorf("no data available for artifact '%s'", act.ArtifactID)
+		}
+		path, err := (*adapter).GetPath(*artifact.Data.Self)
+		if err != nil {
+			return err
+		}
+		fileName := filepath.Join(dir, filepath.FromSlash(act.Path))
+		if err = os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			return err
+		}
+		size := int64(-1)
+		if artifact.Size != nil {
+			size = *artifact.Size
+		}
+		return sdk.DownloadArtifactToFile(ctxt, act.ArtifactID, path, fileName, size, 1, adapter, true, logger)
+	}
+	return nil
+}
+
+func printSyncActions(actions []*syncAction) error {
+	failed := 0
+	for _, act := range actions {
+		if act.Error != "" {
+			failed++
+		}
+	}
+	switch outputFormat {
+	case "json", "yaml":
+		if err := printValue(actions, outputFormat == "yaml"); err != nil {
+			return err
+		}
+	default:
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"Action", "Path", "Artifact"})
+		for _, act := range actions {
+			id := act.ArtifactID
+			if act.Error != "" {
+				id = "FAILED: " + act.Error
+			}
+			t.AppendRow(table.Row{act.Action, act.Path, id})
+		}
+		t.Render()
+	}
+	if failed > 0 {
+		return &PartialSuccessError{Failed: failed, Total: len(actions)}
+	}
+	return nil
+}
+
+// Converts the generic 'value' (as parsed from JSON) into 'result'
+func asType(value interface{}, result interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, result)
+}
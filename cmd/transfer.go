@@ -0,0 +1,82 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	sdk "github.com/reinventingscience/ivcap-cli/pkg"
+)
+
+var limitRate string
+
+// Summary of an upload or download, printed to stderr once it is done
+type transferSummary struct {
+	Bytes       int64   `json:"bytes" yaml:"bytes"`
+	ElapsedSec  float64 `json:"elapsed-sec" yaml:"elapsed-sec"`
+	BytesPerSec float64 `json:"bytes-per-sec" yaml:"bytes-per-sec"`
+	Retries     int64   `json:"retries" yaml:"retries"`
+}
+
+// Returns a context collecting statistics of all transfers made with it, limited to the '--limit-rate'
+func startTransfer(ctxt context.Context) (context.Context, *sdk.TransferStats, error) {
+	rate, err := parseRate(limitRate)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctxt, stats := sdk.WithTransferStats(ctxt, rate)
+	return ctxt, stats, nil
+}
+
+// Parses rates like '5MB/s', '500KiB' or '1000' into bytes per second. Returns 0 for "".
+func parseRate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(s), "/s"), "ps")
+	rate, err := humanize.ParseBytes(v)
+	if err != nil || rate == 0 {
+		return 0, newExitError(EXIT_USAGE, "invalid rate '%s', expected something like '5MB/s'", s)
+	}
+	return int64(rate), nil
+}
+
+// Prints a summary of 'stats', as a single line or, with '-o json|yaml', as object
+func printTransferStats(stats *sdk.TransferStats) {
+	elapsed := stats.Elapsed()
+	summary := transferSummary{
+		Bytes:       atomic.LoadInt64(&stats.Bytes),
+		ElapsedSec:  elapsed.Seconds(),
+		BytesPerSec: stats.Throughput(),
+		Retries:     atomic.LoadInt64(&stats.Retries),
+	}
+	switch outputFormat {
+	case "json", "yaml":
+		fprintValue(os.Stderr, summary, outputFormat == "yaml")
+	default:
+		if silent {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Transferred %s in %v (%s/s), %d retries\n", humanize.Bytes(uint64(summary.Bytes)),
+			elapsed.Round(time.Millisecond), humanize.Bytes(uint64(summary.BytesPerSec)), summary.Retries)
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	sdk "github.com/reinventingscience/ivcap-cli/pkg"
+	a "github.com/reinventingscience/ivcap-cli/pkg/adapter"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	log "go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// Name of the file in the config dir keeping track of unfinished uploads
+const UPLOAD_JOURNAL_FILE_NAME = "uploads.yaml"
+
+// Number of bytes at the start of a file hashed to detect if it changed
+const UPLOAD_HASH_PREFIX_SIZE = 64 * 1024
+
+func init() {
+	artifactCmd.AddCommand(uploadsCmd)
+	uploadsCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "short", "format to use for list (short, yaml, json)")
+	uploadsCmd.AddCommand(listUploadsCmd)
+	uploadsCmd.AddCommand(resumeUploadsCmd)
+	resumeUploadsCmd.Flags().Int64Var(&chunkSize, "chunk-size", DEF_CHUNK_SIZE, "Chunk size for splitting large files")
+	resumeUploadsCmd.Flags().StringVar(&checksum, "checksum", sdk.CHECKSUM_SHA256, "Checksum algorithm to verify uploaded content (sha256, md5, none)")
+	uploadsCmd.AddCommand(abandonUploadsCmd)
+}
+
+type uploadJournalEntry struct {
+	ArtifactID  string    `json:"artifact-id" yaml:"artifact-id"`
+	Deployment  string    `json:"deployment" yaml:"deployment"`
+	UploadURL   string    `json:"upload-url" yaml:"upload-url"`
+	File        string    `json:"file" yaml:"file"`
+	Size        int64     `json:"size" yaml:"size"`
+	ModTime     time.Time `json:"mod-time" yaml:"mod-time"`
+	HashPrefix  string    `json:"hash-prefix" yaml:"hash-prefix"`
+	ContentType string    `json:"content-type" yaml:"content-type"`
+	Offset      int64     `json:"offset" yaml:"offset"` // last known upload offset
+	StartedAt   time.Time `json:"started-at" yaml:"started-at"`
+}
+
+type uploadJournal struct {
+	Uploads []*uploadJournalEntry `json:"uploads" yaml:"uploads"`
+}
+
+var (
+	uploadsCmd = &cobra.Command{
+		Use:   "uploads",
+		Short: "List, resume or abandon unfinished uploads",
+		Args:  cobra.NoArgs,
+		RunE:  listUploads,
+	}
+
+	listUploadsCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List unfinished uploads",
+		Args:  cobra.NoArgs,
+		RunE:  listUploads,
+	}
+
+	resumeUploadsCmd = &cobra.Command{
+		Use:   "resume [artifactID ...]",
+		Short: "Resume unfinished uploads to the current deployment [all if no artifact is given]",
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			journal, err := loadUploadJournal()
+			if err != nil {
+				return err
+			}
+			deployment := GetActiveContext().URL
+			var entries []*uploadJournalEntry
+			if len(args) == 0 {
+				for _, e := range journal.Uploads {
+					if e.Deployment == deployment {
+						entries = append(entries, e)
+					}
+				}
+			} else {
+				for _, id := range args {
+					id = GetHistory(id)
+					e := journal.find(id)
+					if e == nil {
+						return newExitError(EXIT_NOT_FOUND, "no unfinished upload for artifact '%s'", id)
+					}
+					if e.Deployment != deployment {
+						return newExitError(EXIT_USAGE, "upload of artifact '%s' is for deployment '%s'", id, e.Deployment)
+					}
+					entries = append(entries, e)
+				}
+			}
+			if len(entries) == 0 {
+				if !silent {
+					fmt.Println("No unfinished uploads")
+				}
+				return nil
+			}
+			if err := checkChunkSize(cmd); err != nil {
+				return err
+			}
+			if _, err := getChecksumHash(); err != nil {
+				return err
+			}
+			adapter := CreateAdapterWithTimeout(true, DEF_TRANSFER_TIMEOUT)
+			failed := 0
+			for _, e := range entries {
+				if err := resumeUpload(cmd.Context(), e, adapter); err != nil {
+					if len(entries) == 1 {
+						return err
+					}
+					reportError(exitCodeFor(err), err)
+					failed++
+				}
+			}
+			if failed > 0 {
+				return &PartialSuccessError{Failed: failed, Total: len(entries)}
+			}
+			return nil
+		},
+	}
+
+	abandonUploadsCmd = &cobra.Command{
+		Use:   "abandon artifactID [artifactID ...]",
+		Short: "Stop unfinished uploads",
+		Long: `Stops unfinished uploads and no longer tracks them. Uploads to the current
+deployment are also terminated there, which frees the content received so far.`,
+		Aliases: []string{"rm", "remove"},
+		Args:    cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			journal, err := loadUploadJournal()
+			if err != nil {
+				return err
+			}
+			var entries []*uploadJournalEntry
+			for _, id := range args {
+				id = GetHistory(id)
+				e := journal.find(id)
+				if e == nil {
+					return newExitError(EXIT_NOT_FOUND, "no unfinished upload for artifact '%s'", id)
+				}
+				entries = append(entries, e)
+			}
+			deployment := GetActiveContext().URL
+			var adapter *a.Adapter
+			for _, e := range entries {
+				if e.Deployment != deployment {
+					logger.Info("not terminating upload to other deployment", log.String("artifactID", e.ArtifactID),
+						log.String("deployment", e.Deployment))
+					continue
+				}
+				if adapter == nil {
+					adapter = CreateAdapter(true)
+				}
+				if err := terminateJournaledUpload(cmd.Context(), e, adapter); err != nil {
+					return err
+				}
+			}
+			return updateUploadJournal(func(j *uploadJournal) error {
+				for _, e := range entries {
+					j.remove(e.ArtifactID)
+				}
+				return nil
+			})
+		},
+	}
+)
+
+func listUploads(cmd *cobra.Command, args []string) error {
+	journal, err := loadUploadJournal()
+	if err != nil {
+		return err
+	}
+	switch outputFormat {
+	case "json", "yaml":
+		return printValue(journal, outputFormat == "yaml")
+	default:
+		if len(journal.Uploads) == 0 {
+			fmt.Println("No unfinished uploads")
+			return nil
+		}
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"ID", "File", "Size", "Uploaded", "Started", "Deployment"})
+		for _, e := range journal.Uploads {
+			started := e.StartedAt.Format(time.RFC3339)
+			t.AppendRow(table.Row{MakeHistory(&e.ArtifactID), e.File, safeBytes(&e.Size),
+				fmt.Sprintf("%d%%", e.Offset*100/e.Size), safeDate(&started, true), e.Deployment})
+		}
+		t.Render()
+	}
+	return nil
+}
+
+// Continues the upload recorded in 'e', after checking that the file hasn't changed
+func resumeUpload(ctxt context.Context, e *uploadJournalEntry, adapter *a.Adapter) error {
+	current, err := newUploadJournalEntry(e.File, e.Deployment)
+	if err != nil {
+		return err
+	}
+	if !current.sameFile(e) {
+		return newExitError(EXIT_CONFLICT, "file '%s' changed since uploading it to artifact '%s' started", e.File, e.ArtifactID)
+	}
+	path, err := (*adapter).GetPath(e.UploadURL)
+	if err != nil {
+		return err
+	}
+	offset, err := getUploadOffset(ctxt, path, adapter)
+	if err != nil {
+		return fmt.Errorf("while checking on upload status of artifact '%s' - %w", e.ArtifactID, err)
+	}
+	file, err := os.Open(e.File)
+	if err != nil {
+		return fmt.Errorf("while opening data file '%s' - %w", e.File, err)
+	}
+	defer file.Close()
+	if !silent {
+		fmt.Printf("Resuming upload of '%s' to artifact '%s' at %d of %d bytes\n", e.File, e.ArtifactID, offset, e.Size)
+	}
+	digest, err := getChecksumHash()
+	if err != nil {
+		return err
+	}
+	if err = transferContent(ctxt, bufio.NewReader(file), e.File, e.ArtifactID, path, e.Size, offset, digest, silent, adapter); err != nil {
+		return err
+	}
+	if silent {
+		return nil
+	}
+	return printUploadedArtifact(ctxt, e.ArtifactID, adapter)
+}
+
+// Returns an earlier, unfinished upload of the file described by 'entry' together
+// with its path and current offset, or nil if there is none.
+func findJournaledUpload(
+	ctxt context.Context,
+	entry *uploadJournalEntry,
+	adapter *a.Adapter,
+) (prev *uploadJournalEntry, path string, offset int64, err error) {
+	journal, err := loadUploadJournal()
+	if err != nil {
+		return
+	}
+	if prev = journal.findSimilar(entry); prev == nil {
+		return
+	}
+	path, err = (*adapter).GetPath(prev.UploadURL)
+	if err == nil {
+		offset, err = getUploadOffset(ctxt, path, adapter)
+	}
+	if err != nil {
+		var notFound *a.ResourceNotFoundError
+		if !errors.As(err, &notFound) {
+			err = fmt.Errorf("while checking on upload status of artifact '%s' - %w", prev.ArtifactID, err)
+			return
+		}
+		// artifact is gone, start again
+		logger.Info("cannot resume earlier upload", log.String("artifactID", prev.ArtifactID), log.Error(err))
+		err = updateUploadJournal(func(j *uploadJournal) error {
+			j.remove(prev.ArtifactID)
+			return nil
+		})
+		return nil, "", 0, err
+	}
+	return
+}
+
+// Stops the upload recorded in 'e', which lets the deployment free the content received so far
+func terminateJournaledUpload(ctxt context.Context, e *uploadJournalEntry, adapter *a.Adapter) error {
+	path, err := (*adapter).GetPath(e.UploadURL)
+	if err != nil {
+		return err
+	}
+	if err = sdk.TerminateUpload(ctxt, path, adapter, logger); err != nil {
+		var notFound *a.ResourceNotFoundError
+		if errors.As(err, &notFound) {
+			return nil // already gone
+		}
+		return fmt.Errorf("while terminating upload of artifact '%s' - %w", e.ArtifactID, err)
+	}
+	return nil
+}
+
+// Returns the offset the server reports for the tus upload at 'path'
+func getUploadOffset(ctxt context.Context, path string, adapter *a.Adapter) (offset int64, err error) {
+	headers := map[string]string{
+		"Tus-Resumable": "1.0.0",
+	}
+	pyld, err := (*adapter).Head(ctxt, path, &headers, logger)
+	if err != nil {
+		return
+	}
+	offset, err = strconv.ParseInt(pyld.Header("Upload-Offset"), 10, 64)
+	if err != nil {
+		err = fmt.Errorf("problems parsing 'Upload-Offset' in return header '%s' - %w", pyld.Header("Upload-Offset"), err)
+	}
+	return
+}
+
+// Describes the upload of 'fileName' to 'deployment', yet without the artifact it goes to
+func newUploadJournalEntry(fileName string, deployment string) (*uploadJournalEntry, error) {
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("while opening data file '%s' - %w", fileName, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err = io.CopyN(h, file, UPLOAD_HASH_PREFIX_SIZE); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("while reading data file '%s' - %w", fileName, err)
+	}
+	return &uploadJournalEntry{
+		Deployment: deployment,
+		File:       path,
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		HashPrefix: hex.EncodeToString(h.Sum(nil)),
+		StartedAt:  time.Now(),
+	}, nil
+}
+
+// Returns true if both entries refer to the same, unchanged file
+func (e *uploadJournalEntry) sameFile(o *uploadJournalEntry) bool {
+	return e.File == o.File && e.Size == o.Size && e.ModTime.Equal(o.ModTime) && e.HashPrefix == o.HashPrefix
+}
+
+// Returns the unfinished upload of the same file to the same deployment as 'e', or nil
+func (j *uploadJournal) findSimilar(e *uploadJournalEntry) *uploadJournalEntry {
+	for _, u := range j.Uploads {
+		if u.Deployment == e.Deployment && u.sameFile(e) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (j *uploadJournal) find(artifactID string) *uploadJournalEntry {
+	for _, u := range j.Uploads {
+		if u.ArtifactID == artifactID {
+			return u
+		}
+	}
+	return nil
+}
+
+func (j *uploadJournal) remove(artifactID string) {
+	for i, u := range j.Uploads {
+		if u.ArtifactID == artifactID {
+			j.Uploads = append(j.Uploads[:i], j.Uploads[i+1:]...)
+			return
+		}
+	}
+}
+
+func loadUploadJournal() (*uploadJournal, error) {
+	path := makeConfigFilePath(UPLOAD_JOURNAL_FILE_NAME)
+	journal := &uploadJournal{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return journal, nil
+		}
+		return nil, fmt.Errorf("cannot read upload journal %s - %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("problems parsing upload journal %s - %w", path, err)
+	}
+	return journal, nil
+}
+
+// Guards the upload journal file against concurrent uploads
+var uploadJournalLock sync.Mutex
+
+// Loads the upload journal, applies 'f' to it, and saves it again unless 'f' fails
+func updateUploadJournal(f func(j *uploadJournal) error) error {
+	uploadJournalLock.Lock()
+	defer uploadJournalLock.Unlock()
+	journal, err := loadUploadJournal()
+	if err != nil {
+		return err
+	}
+	if err = f(journal); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	path := makeConfigFilePath(UPLOAD_JOURNAL_FILE_NAME)
+	if err = ioutil.WriteFile(path, b, fs.FileMode(0600)); err != nil {
+		return fmt.Errorf("cannot write upload journal %s - %w", path, err)
+	}
+	return nil
+}
+
+// Returns true if the upload journal has an entry for 'artifactID'
+func isUploadJournaled(artifactID string) bool {
+	uploadJournalLock.Lock()
+	defer uploadJournalLock.Unlock()
+	journal, err := loadUploadJournal()
+	return err == nil && journal.find(artifactID) != nil
+}
+
+// Records the progress of an interrupted upload, so that 'artifact uploads' can report it
+func recordUploadOffset(artifactID string, path string, adapter *a.Adapter) {
+	if !isUploadJournaled(artifactID) {
+		// e.g. 'artifact upload' to an artifact created elsewhere
+		return
+	}
+	// the command's context may already be cancelled
+	ctxt, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	offset, err := getUploadOffset(ctxt, path, adapter)
+	if err != nil {
+		logger.Debug("cannot get offset of interrupted upload", log.String("artifactID", artifactID), log.Error(err))
+		return
+	}
+	updateUploadJournal(func(j *uploadJournal) error {
+		if e := j.find(artifactID); e != nil {
+			e.Offset = offset
+		}
+		return nil
+	})
+}
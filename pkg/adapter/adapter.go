@@ -18,6 +18,7 @@ package adapter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -25,6 +26,7 @@ import (
 	"net/url"
 	neturl "net/url"
 	"strings"
+	"sync"
 	"time"
 
 	log "go.uber.org/zap"
@@ -35,9 +37,18 @@ type ConnectionCtxt struct {
 	AccessToken string
 	TimeoutSec  int
 	Headers     *map[string]string // default headers
+	Retry       *RetryPolicy       // no retries if nil
+	Transport   http.RoundTripper  // shared across requests, http.DefaultTransport if nil
+	// Called to obtain a new access token when the current one is rejected (401)
+	TokenRefresher TokenRefresher
+
+	tokenLock *sync.Mutex // guards AccessToken as it may be refreshed by concurrent requests
 }
 
 func RestAdapter(connCtxt ConnectionCtxt) Adapter {
+	if connCtxt.tokenLock == nil {
+		connCtxt.tokenLock = &sync.Mutex{}
+	}
 	return &restAdapter{connCtxt}
 }
 
@@ -60,31 +71,82 @@ type MissingUrlError struct {
 func (e MissingUrlError) Error() string { return "Missing deployment URL" }
 
 type ResourceNotFoundError struct {
-	AdapterError
+	ApiError
+}
+
+func (e ResourceNotFoundError) Error() string {
+	if e.Fault != nil && e.Fault.Message != "" {
+		return "Resource not found - " + e.Fault.Message
+	}
+	return "Resource not found"
 }
 
-func (e ResourceNotFoundError) Error() string { return "Resource not found" }
+func (e *ResourceNotFoundError) Unwrap() error { return &e.ApiError }
 
 type UnauthorizedError struct {
-	AdapterError
+	ApiError
 }
 
-func (e *UnauthorizedError) Error() string { return "Unauthorized access" }
+func (e *UnauthorizedError) Error() string {
+	if e.Fault != nil && e.Fault.Message != "" {
+		return "Unauthorized access - " + e.Fault.Message
+	}
+	return "Unauthorized access"
+}
+
+func (e *UnauthorizedError) Unwrap() error { return &e.ApiError }
+
+// NewUnauthorizedError returns an UnauthorizedError for a request to 'path' which
+// cannot be authorised for the reason given in 'message', without asking the server
+func NewUnauthorizedError(path string, message string) *UnauthorizedError {
+	return &UnauthorizedError{ApiError{
+		AdapterError: AdapterError{path},
+		StatusCode:   http.StatusUnauthorized,
+		Fault:        &ServerFault{Name: "unauthorized", Message: message},
+	}}
+}
 
 type ApiError struct {
 	AdapterError
 	StatusCode int
 	Payload    Payload
+	Fault      *ServerFault // nil if the reply isn't a parsable fault
 }
 
 func (e *ApiError) Error() string {
-	if e.Payload != nil && !e.Payload.IsEmpty() {
+	if e.Fault != nil {
+		return e.Fault.String()
+	} else if e.Payload != nil && !e.Payload.IsEmpty() {
 		return string(e.Payload.AsBytes())
 	} else {
 		return fmt.Sprintf("%d: %s", e.StatusCode, http.StatusText(e.StatusCode))
 	}
 }
 
+// Returns true if the server indicated that the request may succeed if repeated
+func (e *ApiError) Temporary() bool {
+	if e.Fault != nil {
+		return e.Fault.Temporary || e.Fault.Timeout
+	}
+	return isRetryableStatus(e.StatusCode)
+}
+
+// CancelledError is returned when a request is aborted because its context
+// was cancelled or its deadline was exceeded.
+type CancelledError struct {
+	AdapterError
+	err error
+}
+
+func (e *CancelledError) Error() string {
+	if e.err == context.DeadlineExceeded {
+		return "request timed out"
+	}
+	return "request cancelled"
+}
+
+func (e *CancelledError) Unwrap() error { return e.err }
+
 type ClientError struct {
 	AdapterError
 	err error
@@ -94,6 +156,8 @@ func (e *ClientError) Error() string {
 	return fmt.Sprintf("while connecting to IVCAP cluster - %s", e.err.Error())
 }
 
+func (e *ClientError) Unwrap() error { return e.err }
+
 type restAdapter struct {
 	ctxt ConnectionCtxt
 }
@@ -102,6 +166,10 @@ func (a *restAdapter) Head(ctxt context.Context, path string, headers *map[strin
 	return Connect(ctxt, "HEAD", path, nil, -1, headers, &a.ctxt, nil, logger)
 }
 
+func (a *restAdapter) Options(ctxt context.Context, path string, headers *map[string]string, logger *log.Logger) (Payload, error) {
+	return Connect(ctxt, "OPTIONS", path, nil, -1, headers, &a.ctxt, nil, logger)
+}
+
 func (a *restAdapter) Get(ctxt context.Context, path string, logger *log.Logger) (Payload, error) {
 	return Connect(ctxt, "GET", path, nil, -1, nil, &a.ctxt, nil, logger)
 }
@@ -137,6 +205,10 @@ func (a *restAdapter) Delete(ctxt context.Context, path string, logger *log.Logg
 	return Connect(ctxt, "DELETE", path, nil, -1, nil, &a.ctxt, nil, logger)
 }
 
+func (a *restAdapter) Delete2(ctxt context.Context, path string, headers *map[string]string, logger *log.Logger) (Payload, error) {
+	return Connect(ctxt, "DELETE", path, nil, -1, headers, &a.ctxt, nil, logger)
+}
+
 func (a *restAdapter) SetUrl(url string) {
 	a.ctxt.URL = url
 }
@@ -177,7 +249,81 @@ func Connect(
 		url = connCtxt.URL + path
 	}
 	logger = logger.With(log.String("url", url))
-	req, err := http.NewRequest(method, url, body)
+
+	// Only retry requests which can be safely repeated and whose body we can replay
+	rb, canRewind := newRewindableBody(body)
+	tusPatch := isTusPatch(method, headers)
+	maxAttempts := 1
+	if canRewind && (isIdempotent(method) || tusPatch) {
+		maxAttempts = connCtxt.Retry.maxAttempts()
+	}
+	// Only try to refresh a rejected token once per request
+	canRefresh := canRewind && connCtxt.TokenRefresher != nil
+	for attempt := 1; ; attempt++ {
+		token := connCtxt.getAccessToken()
+		resp, err := send(ctxt, method, url, path, body, length, headers, token, connCtxt, logger)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && canRefresh && token != "" {
+			canRefresh = false
+			if rerr := connCtxt.refreshAccessToken(ctxt, token, logger); rerr == nil {
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				if err = rb.rewind(0); err != nil {
+					return nil, &ClientError{AdapterError{path}, err}
+				}
+				attempt-- // replaying with a new token isn't a retry
+				continue
+			} else {
+				logger.Warn("cannot refresh access token", log.Error(rerr))
+			}
+		}
+		var missErr *CassetteMissError
+		if attempt >= maxAttempts || ctxt.Err() != nil || errors.As(err, &missErr) ||
+			(err == nil && !isRetryableStatus(resp.StatusCode)) {
+			if err != nil {
+				return nil, err
+			}
+			return processResponse(ctxt, resp, path, respHandler, logger)
+		}
+		wait := connCtxt.Retry.backoff(attempt, resp)
+		CountRetry(ctxt)
+		if resp != nil {
+			logger.Info("retrying after error response", log.Int("statusCode", resp.StatusCode),
+				log.Int("attempt", attempt), log.Duration("wait", wait))
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			logger.Info("retrying after failed request", log.Error(err),
+				log.Int("attempt", attempt), log.Duration("wait", wait))
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctxt.Done():
+			return nil, &CancelledError{AdapterError{path}, ctxt.Err()}
+		}
+
+		if tusPatch {
+			if headers, length, err = resumeTusPatch(ctxt, path, headers, length, rb, connCtxt, logger); err != nil {
+				return nil, err
+			}
+		} else if err = rb.rewind(0); err != nil {
+			return nil, &ClientError{AdapterError{path}, err}
+		}
+	}
+}
+
+func send(
+	ctxt context.Context,
+	method string,
+	url string,
+	path string,
+	body io.Reader,
+	length int64,
+	headers *map[string]string,
+	accessToken string,
+	connCtxt *ConnectionCtxt,
+	logger *log.Logger,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctxt, method, url, body)
 	if err != nil {
 		logger.Error("Creating http request", log.Error(err))
 		return nil, &ClientError{AdapterError{path}, err}
@@ -195,8 +341,8 @@ func Connect(
 		req.Header.Set("Content-Type", contentType)
 	}
 	req.Header.Set("Cache-Control", "no-cache")
-	if connCtxt.AccessToken != "" {
-		req.Header.Set("Authorization", "Bearer "+connCtxt.AccessToken)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
 	}
 	if connCtxt.Headers != nil {
 		for key, val := range *connCtxt.Headers {
@@ -216,23 +362,33 @@ func Connect(
 	if host != "" {
 		req.Host = host
 	}
-	client := &http.Client{Timeout: time.Second * time.Duration(connCtxt.TimeoutSec)}
+	client := &http.Client{
+		Transport: connCtxt.Transport,
+		Timeout:   time.Second * time.Duration(connCtxt.TimeoutSec),
+	}
 	logger.Debug("calling api", log.Reflect("headers", req.Header))
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.Warn("HTTP request failed.", log.Error(err), log.Reflect("err2", err))
-		return nil, &ClientError{AdapterError{path}, err}
+		return nil, clientError(ctxt, path, err)
 	}
+	return resp, nil
+}
+
+func processResponse(ctxt context.Context, resp *http.Response, path string, respHandler ResponseHandler, logger *log.Logger) (Payload, error) {
 	defer resp.Body.Close()
 
 	if respHandler != nil {
 		err := respHandler(resp, path, logger)
+		if err != nil && ctxt.Err() != nil {
+			return nil, &CancelledError{AdapterError{path}, ctxt.Err()}
+		}
 		return nil, err
 	}
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		logger.Warn("Accessing response body failed.", log.Error(err))
-		return nil, &ClientError{AdapterError{path}, err}
+		return nil, clientError(ctxt, path, err)
 	}
 	logger.Debug("successful reply", log.Int("statusCode", resp.StatusCode),
 		log.Int("body-length", len(respBody)), log.Reflect("headers", resp.Header))
@@ -246,18 +402,42 @@ func Connect(
 	return ToPayload(respBody, resp, logger), nil
 }
 
+// Returns a CancelledError if 'err' was caused by the context being done
+func clientError(ctxt context.Context, path string, err error) error {
+	if ctxt.Err() != nil {
+		return &CancelledError{AdapterError{path}, ctxt.Err()}
+	}
+	return &ClientError{AdapterError{path}, err}
+}
+
+// Maps an error reply to one of the typed errors. All of them can be
+// unwrapped into an ApiError with `errors.As`.
 func ProcessErrorResponse(resp *http.Response, path string, pyld Payload, logger *log.Logger) (err error) {
+	apiErr := ApiError{
+		AdapterError: AdapterError{path},
+		StatusCode:   resp.StatusCode,
+		Payload:      pyld,
+		Fault:        ParseServerFault(pyld),
+	}
 	switch resp.StatusCode {
-	case http.StatusNotFound:
-		return &ResourceNotFoundError{AdapterError{path}}
+	case http.StatusNotFound, http.StatusGone:
+		return &ResourceNotFoundError{apiErr}
 	case http.StatusUnauthorized:
-		return &UnauthorizedError{AdapterError{path}}
+		return &UnauthorizedError{apiErr}
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return &BadRequestError{apiErr}
+	case http.StatusForbidden:
+		return &ForbiddenError{apiErr}
+	case http.StatusConflict:
+		return &ConflictError{apiErr}
+	case http.StatusTooManyRequests:
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"))
+		return &RateLimitedError{apiErr, retryAfter}
 	default:
 		logger.Warn("HTTP response", log.Int("statusCode", resp.StatusCode))
-		return &ApiError{
-			AdapterError: AdapterError{path},
-			StatusCode:   resp.StatusCode,
-			Payload:      pyld,
+		if resp.StatusCode >= 500 {
+			return &ServerError{apiErr}
 		}
+		return &apiErr
 	}
 }
@@ -0,0 +1,107 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+func TestCancelledRequest(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Retry: testRetryPolicy()})
+	ctxt, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := adpt.Get(ctxt, "/1/foo", log.NewNop())
+	var cerr *CancelledError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected CancelledError, but got %T - %v", err, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected error to wrap 'context.DeadlineExceeded', but got %v", err)
+	}
+}
+
+func TestRefreshTokenOnUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	refreshed := 0
+	refresher := func(ctxt context.Context, logger *log.Logger) (string, error) {
+		refreshed++
+		return "new", nil
+	}
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, AccessToken: "old", TimeoutSec: 5, TokenRefresher: refresher})
+	data := `{"a":1}`
+	pyld, err := adpt.Put(context.Background(), "/1/foo", strings.NewReader(data), int64(len(data)), nil, log.NewNop())
+	if err != nil {
+		t.Fatalf("Expected request to succeed after token refresh, but got %v", err)
+	}
+	if string(pyld.AsBytes()) != data {
+		t.Fatalf("Expected body to be replayed, but server received '%s'", pyld.AsBytes())
+	}
+	if _, err = adpt.Get(context.Background(), "/1/foo", log.NewNop()); err != nil {
+		t.Fatalf("Expected refreshed token to be reused, but got %v", err)
+	}
+	if refreshed != 1 {
+		t.Fatalf("Expected token to be refreshed once, but was %d times", refreshed)
+	}
+}
+
+func TestRefreshTokenOnlyOnce(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	refreshed := 0
+	refresher := func(ctxt context.Context, logger *log.Logger) (string, error) {
+		refreshed++
+		return "new", nil
+	}
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, AccessToken: "old", TimeoutSec: 5, TokenRefresher: refresher})
+	_, err := adpt.Get(context.Background(), "/1/foo", log.NewNop())
+	var uerr *UnauthorizedError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("Expected UnauthorizedError, but got %v", err)
+	}
+	if refreshed != 1 {
+		t.Fatalf("Expected token to be refreshed once, but was %d times", refreshed)
+	}
+}
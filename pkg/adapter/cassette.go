@@ -0,0 +1,259 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type CassetteMode int
+
+const (
+	// Pass requests on to the deployment and store the replies
+	CASSETTE_RECORD CassetteMode = iota
+	// Serve stored replies without any network access
+	CASSETTE_REPLAY
+)
+
+// Cassette is a http.RoundTripper which records request/response pairs into
+// a directory, or replays them from there. Requests are matched by method,
+// path (including the query) and a hash of the body. If the same request is
+// made multiple times, the recorded replies are served in the same order, with
+// the last one repeated once they run out.
+//
+// To use recorded cassettes in tests:
+//
+//	cassette, _ := adapter.NewReplayingCassette("testdata/cassettes")
+//	adpt := adapter.RestAdapter(adapter.ConnectionCtxt{URL: "http://replay", Transport: cassette})
+type Cassette struct {
+	Dir  string
+	Mode CassetteMode
+	// Transport used for the actual requests when recording
+	Transport http.RoundTripper
+
+	lock   sync.Mutex
+	tracks map[string]*cassetteTrack
+	played map[string]int
+}
+
+// CassetteMissError is returned when replaying a request which was never recorded
+type CassetteMissError struct {
+	Method string
+	Path   string
+	Dir    string
+}
+
+func (e *CassetteMissError) Error() string {
+	return fmt.Sprintf("no recorded reply for '%s %s' in cassette '%s'", e.Method, e.Path, e.Dir)
+}
+
+// All replies recorded for a specific request, stored as a single file
+type cassetteTrack struct {
+	Method     string                `json:"method"`
+	Path       string                `json:"path"`
+	BodySHA256 string                `json:"body-sha256"`
+	Replies    []cassetteInteraction `json:"replies"`
+}
+
+type cassetteInteraction struct {
+	StatusCode   int                 `json:"status"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body-encoding,omitempty"`
+}
+
+// Headers never stored in a cassette
+var cassetteSkipHeaders = map[string]bool{
+	"Set-Cookie": true,
+	"Date":       true,
+}
+
+// NewRecordingCassette returns a cassette passing all requests on to 'transport'
+// and storing the replies in 'dir', which is created if necessary.
+func NewRecordingCassette(dir string, transport http.RoundTripper) (*Cassette, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create cassette directory '%s' - %v", dir, err)
+	}
+	return &Cassette{Dir: dir, Mode: CASSETTE_RECORD, Transport: transport}, nil
+}
+
+// NewReplayingCassette returns a cassette serving the replies previously recorded in 'dir'
+func NewReplayingCassette(dir string) (*Cassette, error) {
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("cassette directory '%s' does not exist", dir)
+	}
+	return &Cassette{Dir: dir, Mode: CASSETTE_REPLAY}, nil
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	bodyHash := sha256.Sum256(body)
+	track := &cassetteTrack{
+		Method:     req.Method,
+		Path:       redactURL(req.URL).RequestURI(),
+		BodySHA256: hex.EncodeToString(bodyHash[:]),
+	}
+	if c.Mode == CASSETTE_REPLAY {
+		return c.replay(req, track)
+	}
+	return c.record(req, body, track)
+}
+
+func (c *Cassette) replay(req *http.Request, track *cassetteTrack) (*http.Response, error) {
+	fileName := c.fileName(track)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tracks == nil {
+		c.tracks = map[string]*cassetteTrack{}
+		c.played = map[string]int{}
+	}
+	recorded, ok := c.tracks[fileName]
+	if !ok {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return nil, &CassetteMissError{track.Method, track.Path, c.Dir}
+		}
+		recorded = &cassetteTrack{}
+		if err := json.Unmarshal(data, recorded); err != nil {
+			return nil, fmt.Errorf("cannot parse cassette file '%s' - %v", fileName, err)
+		}
+		if len(recorded.Replies) == 0 {
+			return nil, &CassetteMissError{track.Method, track.Path, c.Dir}
+		}
+		c.tracks[fileName] = recorded
+	}
+	idx := c.played[fileName]
+	if idx >= len(recorded.Replies) {
+		idx = len(recorded.Replies) - 1
+	}
+	c.played[fileName]++
+	return recorded.Replies[idx].toResponse(req)
+}
+
+func (c *Cassette) record(req *http.Request, body []byte, track *cassetteTrack) (*http.Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if body != nil {
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	reply := cassetteInteraction{StatusCode: resp.StatusCode, Headers: map[string][]string{}}
+	for k, v := range resp.Header {
+		if !cassetteSkipHeaders[k] {
+			reply.Headers[k] = v
+		}
+	}
+	if utf8.Valid(respBody) {
+		// credentials are replaced, but the reply stays parseable for replaying
+		reply.Body = redactBody(string(respBody))
+	} else {
+		reply.Body = base64.StdEncoding.EncodeToString(respBody)
+		reply.BodyEncoding = "base64"
+	}
+
+	fileName := c.fileName(track)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tracks == nil {
+		c.tracks = map[string]*cassetteTrack{}
+	}
+	// replies recorded by an earlier run are replaced
+	if prev, ok := c.tracks[fileName]; ok {
+		track = prev
+	} else {
+		c.tracks[fileName] = track
+	}
+	track.Replies = append(track.Replies, reply)
+	data, err := json.MarshalIndent(track, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		return nil, fmt.Errorf("cannot write cassette file '%s' - %v", fileName, err)
+	}
+	return resp, nil
+}
+
+var cassetteSlugRE = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// Returns the name of the file holding all replies to requests matching 'track'.
+// It is readable, but made unique by a hash over method, path and body.
+func (c *Cassette) fileName(track *cassetteTrack) string {
+	key := sha256.Sum256([]byte(track.Method + " " + track.Path + " " + track.BodySHA256))
+	slug := strings.Trim(cassetteSlugRE.ReplaceAllString(track.Path, "_"), "_")
+	if len(slug) > 60 {
+		slug = slug[:60]
+	}
+	name := fmt.Sprintf("%s-%s-%s.json", strings.ToLower(track.Method), slug, hex.EncodeToString(key[:6]))
+	return filepath.Join(c.Dir, name)
+}
+
+func (i *cassetteInteraction) toResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(i.Body)
+	if i.BodyEncoding == "base64" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(i.Body); err != nil {
+			return nil, err
+		}
+	}
+	header := http.Header{}
+	for k, v := range i.Headers {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.StatusCode, http.StatusText(i.StatusCode)),
+		StatusCode:    i.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
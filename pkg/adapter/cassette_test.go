@@ -0,0 +1,122 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "go.uber.org/zap"
+)
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	}))
+	dir := t.TempDir()
+	body := []byte(`{"name":"foo"}`)
+
+	recorder, err := NewRecordingCassette(dir, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Transport: recorder})
+	for i := 0; i < 2; i++ {
+		if _, err := adpt.Get(context.Background(), "/1/foo", log.NewNop()); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if _, err := adpt.Post(context.Background(), "/1/foo", bytes.NewReader(body), int64(len(body)), nil, log.NewNop()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	srv.Close()
+
+	replayer, err := NewReplayingCassette(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	adpt = RestAdapter(ConnectionCtxt{URL: "http://replay", TimeoutSec: 5, Transport: replayer})
+	for _, expected := range []string{`{"call":1}`, `{"call":2}`, `{"call":2}`} {
+		pyld, err := adpt.Get(context.Background(), "/1/foo", log.NewNop())
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if string(pyld.AsBytes()) != expected {
+			t.Fatalf("Expected '%s', but got '%s'", expected, pyld.AsBytes())
+		}
+	}
+	pyld, err := adpt.Post(context.Background(), "/1/foo", bytes.NewReader(body), int64(len(body)), nil, log.NewNop())
+	if err != nil || string(pyld.AsBytes()) != `{"call":3}` {
+		t.Fatalf("Expected recorded POST reply, but got %v", err)
+	}
+
+	other := []byte(`{"name":"bar"}`)
+	_, err = adpt.Post(context.Background(), "/1/foo", bytes.NewReader(other), int64(len(other)), nil, log.NewNop())
+	var missErr *CassetteMissError
+	if !errors.As(err, &missErr) {
+		t.Fatalf("Expected CassetteMissError for unrecorded body, but got %v", err)
+	}
+}
+
+func TestCassetteRedactsTokens(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"tok-access","refresh_token":"tok-refresh","expires_in":3600}`)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	recorder, err := NewRecordingCassette(dir, http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Transport: recorder})
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"tok-old-refresh"}}
+	if _, err := adpt.PostForm(context.Background(), srv.URL+"/oauth/token?access_token=tok-query", form, nil, log.NewNop()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 cassette file, but got %v", files)
+	}
+	data, _ := ioutil.ReadFile(files[0])
+	if strings.Contains(string(data), "tok-") || !strings.Contains(string(data), "expires_in") {
+		t.Fatalf("Expected tokens to be redacted, but got\n%s", data)
+	}
+
+	replayer, _ := NewReplayingCassette(dir)
+	adpt = RestAdapter(ConnectionCtxt{URL: "http://replay", TimeoutSec: 5, Transport: replayer})
+	pyld, err := adpt.PostForm(context.Background(), "http://replay/oauth/token?access_token=tok-query", form, nil, log.NewNop())
+	if err != nil {
+		t.Fatalf("Expected redacted reply to be replayed, but got %v", err)
+	}
+	var reply struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := pyld.AsType(&reply); err != nil || reply.AccessToken != REDACTED {
+		t.Fatalf("Unexpected replayed reply '%s'", pyld.AsBytes())
+	}
+}
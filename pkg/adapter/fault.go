@@ -0,0 +1,96 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ServerFault is the error body returned by the IVCAP API (Goa error format)
+type ServerFault struct {
+	// Name of the error, such as 'bad_request' or 'not_found'
+	Name string `json:"name" yaml:"name"`
+	// ID of this error instance, useful when reporting issues
+	ID string `json:"id" yaml:"id"`
+	// Human readable description
+	Message string `json:"message" yaml:"message"`
+	// Request may succeed if repeated
+	Temporary bool `json:"temporary" yaml:"temporary"`
+	// Request timed out on the server
+	Timeout bool `json:"timeout" yaml:"timeout"`
+	// Problem is on the server side
+	Fault bool `json:"fault" yaml:"fault"`
+}
+
+func (f *ServerFault) String() string {
+	if f.Name == "" {
+		return f.Message
+	}
+	return fmt.Sprintf("%s: %s", f.Name, f.Message)
+}
+
+// Returns the fault contained in an error reply, or nil if
+// the reply doesn't follow the fault format.
+func ParseServerFault(pyld Payload) *ServerFault {
+	if pyld == nil || pyld.IsEmpty() {
+		return nil
+	}
+	var fault ServerFault
+	if err := json.Unmarshal(pyld.AsBytes(), &fault); err != nil {
+		return nil
+	}
+	if fault.Message == "" {
+		return nil
+	}
+	return &fault
+}
+
+// 400 & 422 - The request was malformed or failed validation
+type BadRequestError struct {
+	ApiError
+}
+
+func (e *BadRequestError) Unwrap() error { return &e.ApiError }
+
+// 403 - The caller isn't allowed to perform this request
+type ForbiddenError struct {
+	ApiError
+}
+
+func (e *ForbiddenError) Unwrap() error { return &e.ApiError }
+
+// 409 - The request conflicts with the current state of the resource
+type ConflictError struct {
+	ApiError
+}
+
+func (e *ConflictError) Unwrap() error { return &e.ApiError }
+
+// 429 - Too many requests
+type RateLimitedError struct {
+	ApiError
+	RetryAfter time.Duration // zero if not provided by server
+}
+
+func (e *RateLimitedError) Unwrap() error { return &e.ApiError }
+
+// 5xx - Server failed to process the request
+type ServerError struct {
+	ApiError
+}
+
+func (e *ServerError) Unwrap() error { return &e.ApiError }
@@ -0,0 +1,102 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "go.uber.org/zap"
+)
+
+func TestTypedErrors(t *testing.T) {
+	fault := `{"name":"invalid_parameter","id":"abc123","message":"'limit' must be positive","temporary":false,"timeout":false,"fault":false}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/400":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fault))
+		case "/401":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"name":"unauthorized","id":"def456","message":"token expired"}`))
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"name":"not_found","id":"ghi789","message":"artifact not found"}`))
+		case "/403":
+			w.WriteHeader(http.StatusForbidden)
+		case "/409":
+			w.WriteHeader(http.StatusConflict)
+		case "/429":
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5})
+	get := func(path string) error {
+		_, err := adpt.Get(context.Background(), path, log.NewNop())
+		return err
+	}
+
+	err := get("/400")
+	var badReq *BadRequestError
+	if !errors.As(err, &badReq) {
+		t.Fatalf("Expected BadRequestError, but got %T", err)
+	}
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("Expected to unwrap into ApiError with status 400, but got %v", err)
+	}
+	if apiErr.Fault == nil || apiErr.Fault.ID != "abc123" {
+		t.Fatalf("Expected parsed fault, but got %+v", apiErr.Fault)
+	}
+	if err.Error() != "invalid_parameter: 'limit' must be positive" {
+		t.Fatalf("Unexpected error message '%s'", err.Error())
+	}
+
+	var notFound *ResourceNotFoundError
+	if err = get("/404"); !errors.As(err, &notFound) {
+		t.Fatalf("Expected ResourceNotFoundError, but got %T", err)
+	}
+	if err.Error() != "Resource not found - artifact not found" {
+		t.Fatalf("Unexpected error message '%s'", err.Error())
+	}
+	var unauthorized *UnauthorizedError
+	if err = get("/401"); !errors.As(err, &unauthorized) {
+		t.Fatalf("Expected UnauthorizedError, but got %T", err)
+	}
+	if err.Error() != "Unauthorized access - token expired" {
+		t.Fatalf("Unexpected error message '%s'", err.Error())
+	}
+
+	var forbidden *ForbiddenError
+	if err = get("/403"); !errors.As(err, &forbidden) {
+		t.Fatalf("Expected ForbiddenError, but got %T", err)
+	}
+	var conflict *ConflictError
+	if err = get("/409"); !errors.As(err, &conflict) {
+		t.Fatalf("Expected ConflictError, but got %T", err)
+	}
+	var rateLimited *RateLimitedError
+	if err = get("/429"); !errors.As(err, &rateLimited) {
+		t.Fatalf("Expected RateLimitedError, but got %T", err)
+	}
+	if rateLimited.RetryAfter.Seconds() != 7 {
+		t.Fatalf("Expected RetryAfter of 7s, but got %v", rateLimited.RetryAfter)
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	log "go.uber.org/zap"
+)
+
+// Max. number of bytes of a request or response body kept in a HAR file
+const DEF_HAR_MAX_BODY_SIZE = 64 * 1024
+
+// Max. number of bytes of all request and response bodies together kept in a HAR file
+const DEF_HAR_MAX_TOTAL_BODY_SIZE = 16 * 1024 * 1024
+
+const HAR_REDACTED = REDACTED
+
+// Headers whose values are never written to a HAR file
+var harRedactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// HarRecorder is a http.RoundTripper which records all requests and responses
+// passing through it and can save them as a HAR (HTTP Archive) file.
+type HarRecorder struct {
+	// Transport used for the actual requests. Defaults to http.DefaultTransport
+	Transport http.RoundTripper
+	// Bodies larger than this are truncated
+	MaxBodySize int
+	// Once all bodies kept together reach this size, further bodies are dropped (0 ... no limit)
+	MaxTotalBodySize int64
+	// Reported as the 'creator' of the HAR file
+	CreatorName    string
+	CreatorVersion string
+	// Told when bodies start getting dropped
+	Logger *log.Logger
+
+	lock         sync.Mutex
+	entries      []*harRecord
+	bodySize     atomic.Int64
+	limitReached atomic.Bool
+}
+
+type harRecord struct {
+	started  time.Time
+	waited   time.Duration
+	finished time.Time
+	request  *http.Request
+	response *http.Response
+	err      error
+	reqBody  *harBody
+	respBody *harBody
+}
+
+// NewHarRecorder returns a recorder passing all requests on to 'transport'
+func NewHarRecorder(transport http.RoundTripper, creatorName string, creatorVersion string) *HarRecorder {
+	return &HarRecorder{
+		Transport:        transport,
+		MaxBodySize:      DEF_HAR_MAX_BODY_SIZE,
+		MaxTotalBodySize: DEF_HAR_MAX_TOTAL_BODY_SIZE,
+		CreatorName:      creatorName,
+		CreatorVersion:   creatorVersion,
+		Logger:           log.NewNop(),
+	}
+}
+
+func (h *HarRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := h.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	rec := &harRecord{started: time.Now(), request: req}
+	if req.Body != nil && req.Body != http.NoBody {
+		rec.reqBody = &harBody{ReadCloser: req.Body, max: h.MaxBodySize, reserve: h.reserveBody}
+		req = req.Clone(req.Context())
+		req.Body = rec.reqBody
+	}
+	h.lock.Lock()
+	h.entries = append(h.entries, rec)
+	h.lock.Unlock()
+
+	resp, err := transport.RoundTrip(req)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	rec.waited = time.Since(rec.started)
+	rec.response = resp
+	rec.err = err
+	if resp != nil && resp.Body != nil {
+		rec.respBody = &harBody{ReadCloser: resp.Body, max: h.MaxBodySize, reserve: h.reserveBody, onClose: func() {
+			h.lock.Lock()
+			rec.finished = time.Now()
+			h.lock.Unlock()
+		}}
+		resp.Body = rec.respBody
+	}
+	return resp, err
+}
+
+// Returns how many of 'n' further body bytes can be kept without exceeding 'MaxTotalBodySize'
+func (h *HarRecorder) reserveBody(n int) int {
+	if h.MaxTotalBodySize <= 0 {
+		return n
+	}
+	total := h.bodySize.Add(int64(n))
+	if over := total - h.MaxTotalBodySize; over > 0 {
+		if over >= int64(n) {
+			n = 0
+		} else {
+			n -= int(over)
+		}
+		if h.limitReached.CompareAndSwap(false, true) && h.Logger != nil {
+			h.Logger.Warn("HAR recording reached its size limit, further bodies are dropped",
+				log.Int64("limit", h.MaxTotalBodySize))
+		}
+	}
+	return n
+}
+
+// Save writes all requests recorded so far as a HAR file to 'fileName'
+func (h *HarRecorder) Save(fileName string) error {
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(fileName, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("cannot write HAR file '%s' - %v", fileName, err)
+	}
+	return nil
+}
+
+// WriteTo writes all requests recorded so far in HAR format to 'w'
+func (h *HarRecorder) WriteTo(w io.Writer) (int64, error) {
+	h.lock.Lock()
+	entries := make([]harEntry, 0, len(h.entries))
+	for _, rec := range h.entries {
+		entries = append(entries, rec.toEntry())
+	}
+	h.lock.Unlock()
+
+	doc := harDoc{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: h.CreatorName, Version: h.CreatorVersion},
+		Entries: entries,
+	}}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (rec *harRecord) toEntry() harEntry {
+	req := rec.request
+	total := rec.waited
+	if !rec.finished.IsZero() {
+		total = rec.finished.Sub(rec.started)
+	}
+	e := harEntry{
+		StartedDateTime: rec.started.Format(time.RFC3339Nano),
+		Time:            toMillis(total),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         redactURL(req.URL).String(),
+			HTTPVersion: req.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(req.Header, req.Host),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+		Response: harResponse{
+			HTTPVersion: "",
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			Content:     harContent{MimeType: ""},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Cache:   struct{}{},
+		Timings: harTimings{Send: 0, Wait: toMillis(rec.waited), Receive: toMillis(total - rec.waited)},
+	}
+	for k, vs := range redactURL(req.URL).Query() {
+		for _, v := range vs {
+			e.Request.QueryString = append(e.Request.QueryString, harNameValue{k, v})
+		}
+	}
+	// requests for tokens carry credentials in both directions, so none of their content is kept
+	tokenRequest := false
+	if rec.reqBody != nil {
+		text, enc, comment := rec.reqBody.content()
+		if tokenRequest = isTokenRequest(req, text); tokenRequest {
+			text, enc, comment = HAR_REDACTED, "", ""
+		} else if enc == "" {
+			text = redactBody(text)
+		}
+		e.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: text, Encoding: enc, Comment: comment}
+	} else {
+		tokenRequest = isTokenRequest(req, "")
+	}
+	if resp := rec.response; resp != nil {
+		e.Response.Status = resp.StatusCode
+		e.Response.StatusText = http.StatusText(resp.StatusCode)
+		e.Response.HTTPVersion = resp.Proto
+		e.Response.Headers = harHeaders(resp.Header, "")
+		e.Response.RedirectURL = resp.Header.Get("Location")
+		e.Response.Content.MimeType = resp.Header.Get("Content-Type")
+		if rec.respBody != nil {
+			c := &e.Response.Content
+			c.Text, c.Encoding, c.Comment = rec.respBody.content()
+			if tokenRequest {
+				c.Text, c.Encoding, c.Comment = HAR_REDACTED, "", ""
+			} else if c.Encoding == "" {
+				c.Text = redactBody(c.Text)
+			}
+			e.Response.Content.Size = rec.respBody.bytesRead()
+			e.Response.BodySize = e.Response.Content.Size
+		}
+	}
+	if rec.err != nil {
+		e.Response.Error = rec.err.Error()
+	}
+	return e
+}
+
+func harHeaders(header http.Header, host string) []harNameValue {
+	hs := []harNameValue{}
+	if host != "" {
+		hs = append(hs, harNameValue{"Host", host})
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			if harRedactedHeaders[http.CanonicalHeaderKey(k)] {
+				v = HAR_REDACTED
+			}
+			hs = append(hs, harNameValue{k, v})
+		}
+	}
+	sort.SliceStable(hs, func(i, j int) bool { return hs[i].Name < hs[j].Name })
+	return hs
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harBody keeps the first 'max' bytes read from a body, as far as 'reserve' allows
+type harBody struct {
+	io.ReadCloser
+	max     int
+	reserve func(n int) int
+	size    int64
+	buf     bytes.Buffer
+	onClose func()
+	lock    sync.Mutex
+}
+
+func (b *harBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if keep := b.max - b.buf.Len(); keep > 0 {
+		if keep > n {
+			keep = n
+		}
+		if b.reserve != nil {
+			keep = b.reserve(keep)
+		}
+		b.buf.Write(p[:keep])
+	}
+	b.size += int64(n)
+	return
+}
+
+func (b *harBody) Close() error {
+	if b.onClose != nil {
+		b.onClose()
+		b.onClose = nil
+	}
+	return b.ReadCloser.Close()
+}
+
+func (b *harBody) bytesRead() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.size
+}
+
+// Returns the captured body, its encoding, and a comment if it got truncated
+func (b *harBody) content() (text string, encoding string, comment string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	data := b.buf.Bytes()
+	if utf8.Valid(data) {
+		text = string(data)
+	} else {
+		text = base64.StdEncoding.EncodeToString(data)
+		encoding = "base64"
+	}
+	if b.size > int64(len(data)) {
+		comment = fmt.Sprintf("truncated to %d of %d bytes", len(data), b.size)
+	}
+	return
+}
+
+/**** HAR 1.2 format - http://www.softwareishard.com/blog/har-12-spec/ ****/
+
+type harDoc struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	// Set if no response was received
+	Error string `json:"_error,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	log "go.uber.org/zap"
+)
+
+func TestHarRecorder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer srv.Close()
+
+	rec := NewHarRecorder(http.DefaultTransport, "test", "0.0")
+	rec.MaxBodySize = 4
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, AccessToken: "secret", TimeoutSec: 5, Transport: rec})
+	body := []byte(`{"name":"foo"}`)
+	if _, err := adpt.Post(context.Background(), "/1/foo", bytes.NewReader(body), int64(len(body)), nil, log.NewNop()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Fatalf("Expected access token to be redacted")
+	}
+	var doc harDoc
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected valid HAR file, but got %v", err)
+	}
+	if len(doc.Log.Entries) != 1 {
+		t.Fatalf("Expected 1 entry, but got %d", len(doc.Log.Entries))
+	}
+	e := doc.Log.Entries[0]
+	if e.Request.PostData == nil || e.Request.PostData.Text != `{"na` || e.Request.PostData.Comment == "" {
+		t.Fatalf("Expected request body to be truncated, but got %+v", e.Request.PostData)
+	}
+	if e.Response.Status != 200 || e.Response.Content.Text != `{"ok` {
+		t.Fatalf("Unexpected response %+v", e.Response)
+	}
+}
+
+func TestHarRecorderLimitsTotalBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer srv.Close()
+
+	rec := NewHarRecorder(http.DefaultTransport, "test", "0.0")
+	rec.MaxTotalBodySize = 20
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Transport: rec})
+	body := []byte(`{"name":"foo"}`)
+	for i := 0; i < 2; i++ {
+		if _, err := adpt.Post(context.Background(), "/1/foo", bytes.NewReader(body), int64(len(body)), nil, log.NewNop()); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	var doc harDoc
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected valid HAR file, but got %v", err)
+	}
+	if len(doc.Log.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, but got %d", len(doc.Log.Entries))
+	}
+	first, second := doc.Log.Entries[0], doc.Log.Entries[1]
+	if first.Request.PostData.Text != string(body) || first.Response.Content.Text != `{"ok":` {
+		t.Fatalf("Expected first response to be truncated, but got %+v", first)
+	}
+	if second.Request.PostData.Text != "" || second.Request.PostData.Comment == "" || second.Response.Content.Text != "" {
+		t.Fatalf("Expected bodies of second request to be dropped, but got %+v", second)
+	}
+}
+
+func TestHarRecorderRedactsTokens(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth/token" {
+			fmt.Fprint(w, `{"access_token":"tok-access","refresh_token":"tok-refresh","id_token":"tok-id"}`)
+		} else {
+			fmt.Fprint(w, `{"ok":true,"token":"tok-other"}`)
+		}
+	}))
+	defer srv.Close()
+
+	rec := NewHarRecorder(http.DefaultTransport, "test", "0.0")
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Transport: rec})
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"tok-old-refresh"}, "client_id": {"cli"}}
+	if _, err := adpt.PostForm(context.Background(), srv.URL+"/oauth/token", form, nil, log.NewNop()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := adpt.Get(context.Background(), "/1/foo?access_token=tok-query&limit=5", log.NewNop()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Contains(buf.String(), "tok-") {
+		t.Fatalf("Expected all tokens to be redacted, but got\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "limit=5") {
+		t.Fatalf("Expected other query parameters to be kept, but got\n%s", buf.String())
+	}
+}
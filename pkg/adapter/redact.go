@@ -0,0 +1,67 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Replaces credentials in recorded requests and responses
+const REDACTED = "REDACTED"
+
+// Query parameters, form fields and JSON properties holding credentials
+var redactedFields = []string{
+	"access_token", "refresh_token", "id_token", "device_code", "client_secret", "password", "code_verifier", "token",
+}
+
+var (
+	// matches secret fields in query strings and form bodies, even if truncated
+	redactFormRE = regexp.MustCompile(`((?:^|&)(?:` + strings.Join(redactedFields, "|") + `)=)[^&]*`)
+	// matches form bodies requesting a token
+	grantTypeRE = regexp.MustCompile(`(?:^|&)grant_type=`)
+	// matches secret properties in JSON bodies, even if truncated
+	redactJSONRE = regexp.MustCompile(`("(?:` + strings.Join(redactedFields, "|") + `)"\s*:\s*")(?:[^"\\]|\\.)*`)
+)
+
+// Returns true if 'req' with body 'body' asks an OAuth endpoint for tokens or
+// device codes. Both its request and response carry credentials.
+func isTokenRequest(req *http.Request, body string) bool {
+	path := strings.ToLower(req.URL.Path)
+	if strings.HasSuffix(path, "/token") || strings.HasSuffix(path, "/device/code") {
+		return true
+	}
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") &&
+		grantTypeRE.MatchString(body)
+}
+
+// Returns 'u' with the values of all query parameters holding credentials redacted
+func redactURL(u *url.URL) *url.URL {
+	if u.RawQuery == "" || !redactFormRE.MatchString(u.RawQuery) {
+		return u
+	}
+	r := *u
+	r.RawQuery = redactFormRE.ReplaceAllString(u.RawQuery, "${1}"+REDACTED)
+	return &r
+}
+
+// Returns the text 'body' with the values of all form fields and JSON properties
+// holding credentials redacted
+func redactBody(body string) string {
+	body = redactJSONRE.ReplaceAllString(body, "${1}"+REDACTED)
+	return redactFormRE.ReplaceAllString(body, "${1}"+REDACTED)
+}
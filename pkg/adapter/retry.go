@@ -0,0 +1,224 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+const DEF_RETRY_MAX_ATTEMPTS = 4
+const DEF_RETRY_INITIAL_BACKOFF = 500 * time.Millisecond
+const DEF_RETRY_MAX_BACKOFF = 30 * time.Second
+
+// RetryPolicy defines if and how often a failed request is re-issued. Only
+// requests which can safely be repeated are retried, which are the idempotent
+// HTTP methods and tus PATCH requests (which are verified with a HEAD request
+// before resuming).
+type RetryPolicy struct {
+	// Max. number of attempts, including the first one. A value of 1 or less
+	// disables retries.
+	MaxAttempts int
+	// Backoff before the first retry. It doubles with every further attempt.
+	InitialBackoff time.Duration
+	// Upper limit for the backoff, as well as for any 'Retry-After' requested by the server
+	MaxBackoff time.Duration
+	// Fraction [0..1] of the backoff which is randomised to avoid synchronised retries
+	Jitter float64
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    DEF_RETRY_MAX_ATTEMPTS,
+		InitialBackoff: DEF_RETRY_INITIAL_BACKOFF,
+		MaxBackoff:     DEF_RETRY_MAX_BACKOFF,
+		Jitter:         0.2,
+	}
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Returns the time to wait before attempt number 'attempt' (starting at 1 for
+// the first retry). A 'Retry-After' header on 429 and 503 replies takes
+// precedence over the computed backoff.
+func (p *RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return p.capBackoff(d)
+		}
+	}
+	d := float64(p.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return p.capBackoff(time.Duration(d))
+}
+
+func (p *RetryPolicy) capBackoff(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+type retryCounterKey struct{}
+
+// WithRetryCounter returns a context which adds every retry of a request made with it to 'counter'
+func WithRetryCounter(ctxt context.Context, counter *int64) context.Context {
+	return context.WithValue(ctxt, retryCounterKey{}, counter)
+}
+
+// CountRetry adds one to the retry counter of 'ctxt', if it has one
+func CountRetry(ctxt context.Context) {
+	if counter, ok := ctxt.Value(retryCounterKey{}).(*int64); ok {
+		atomic.AddInt64(counter, 1)
+	}
+}
+
+// 'Retry-After' is either a number of seconds or an HTTP date
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	default:
+		return false
+	}
+}
+
+func isTusPatch(method string, headers *map[string]string) bool {
+	if method != "PATCH" || headers == nil {
+		return false
+	}
+	_, ok := (*headers)["Tus-Resumable"]
+	return ok
+}
+
+// rewindableBody remembers the initial position of a request body so that it
+// can be replayed for a retry. Bodies which are not seekable can only be sent once.
+type rewindableBody struct {
+	seeker io.Seeker
+	start  int64
+}
+
+func newRewindableBody(body io.Reader) (rb *rewindableBody, ok bool) {
+	if body == nil {
+		return nil, true
+	}
+	seeker, isSeeker := body.(io.Seeker)
+	if !isSeeker {
+		return nil, false
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	return &rewindableBody{seeker, start}, true
+}
+
+// Position the body 'skip' bytes past its initial position
+func (rb *rewindableBody) rewind(skip int64) error {
+	if rb == nil {
+		return nil
+	}
+	_, err := rb.seeker.Seek(rb.start+skip, io.SeekStart)
+	return err
+}
+
+// Before re-issuing an interrupted tus PATCH we need to find out how much of
+// the chunk has already been received by the server and skip those bytes.
+// Returns the headers and length to use for the resumed request.
+func resumeTusPatch(
+	ctxt context.Context,
+	path string,
+	headers *map[string]string,
+	length int64,
+	rb *rewindableBody,
+	connCtxt *ConnectionCtxt,
+	logger *log.Logger,
+) (*map[string]string, int64, error) {
+	reqOffset, err := strconv.ParseInt((*headers)["Upload-Offset"], 10, 64)
+	if err != nil {
+		return nil, 0, &ClientError{AdapterError{path}, fmt.Errorf("cannot resume upload without valid 'Upload-Offset' - %v", err)}
+	}
+	h := map[string]string{"Tus-Resumable": (*headers)["Tus-Resumable"]}
+	pyld, err := Connect(ctxt, "HEAD", path, nil, -1, &h, connCtxt, nil, logger)
+	if err != nil {
+		return nil, 0, err
+	}
+	srvOffset, err := strconv.ParseInt(pyld.Header("Upload-Offset"), 10, 64)
+	if err != nil {
+		return nil, 0, &ClientError{AdapterError{path}, fmt.Errorf("problems parsing 'Upload-Offset' in return header '%s' - %v", pyld.Header("Upload-Offset"), err)}
+	}
+	skip := srvOffset - reqOffset
+	if skip < 0 || (length >= 0 && skip > length) || (rb == nil && skip > 0) {
+		return nil, 0, &ClientError{AdapterError{path}, fmt.Errorf("cannot resume upload, server reports offset %d but chunk starts at %d", srvOffset, reqOffset)}
+	}
+	if err = rb.rewind(skip); err != nil {
+		return nil, 0, &ClientError{AdapterError{path}, err}
+	}
+	logger.Debug("resuming upload", log.Int64("offset", srvOffset), log.Int64("skip", skip))
+
+	nh := make(map[string]string, len(*headers))
+	for k, v := range *headers {
+		nh[k] = v
+	}
+	nh["Upload-Offset"] = fmt.Sprintf("%d", srvOffset)
+	if length > 0 {
+		length -= skip
+	}
+	return &nh, length, nil
+}
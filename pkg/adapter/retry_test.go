@@ -0,0 +1,120 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "go.uber.org/zap"
+)
+
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+}
+
+func TestRetryOnServiceUnavailable(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer srv.Close()
+
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Retry: testRetryPolicy()})
+	var retries int64
+	ctxt := WithRetryCounter(context.Background(), &retries)
+	if _, err := adpt.Get(ctxt, "/1/foo", log.NewNop()); err != nil {
+		t.Fatalf("Expected success after retries, but got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("Expected 3 calls, but got %d", calls)
+	}
+	if retries != 2 {
+		t.Fatalf("Expected 2 retries to be counted, but got %d", retries)
+	}
+}
+
+func TestNoRetryForPost(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Retry: testRetryPolicy()})
+	body := []byte(`{}`)
+	if _, err := adpt.Post(context.Background(), "/1/foo", bytes.NewReader(body), int64(len(body)), nil, log.NewNop()); err == nil {
+		t.Fatalf("Expected POST to fail")
+	}
+	if calls != 1 {
+		t.Fatalf("Expected POST not to be retried, but got %d calls", calls)
+	}
+}
+
+func TestRetryResumesTusPatch(t *testing.T) {
+	var received bytes.Buffer
+	failed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "HEAD":
+			w.Header().Set("Upload-Offset", fmt.Sprintf("%d", received.Len()))
+		case "PATCH":
+			if r.Header.Get("Upload-Offset") != fmt.Sprintf("%d", received.Len()) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			if !failed {
+				// simulate that only part of the chunk made it before the gateway gave up
+				failed = true
+				received.Write(data[:4])
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			received.Write(data)
+			w.Header().Set("Upload-Offset", fmt.Sprintf("%d", received.Len()))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Retry: testRetryPolicy()})
+	data := "0123456789"
+	h := map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+		"Tus-Resumable": "1.0.0",
+	}
+	if _, err := adpt.Patch(context.Background(), "/1/artifacts/a", strings.NewReader(data), int64(len(data)), &h, log.NewNop()); err != nil {
+		t.Fatalf("Expected resumed PATCH to succeed, but got %v", err)
+	}
+	if received.String() != data {
+		t.Fatalf("Expected server to receive '%s', but got '%s'", data, received.String())
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+
+	log "go.uber.org/zap"
+)
+
+// TokenRefresher is called when the server rejects the current access token
+// and is expected to return a fresh one.
+type TokenRefresher func(ctxt context.Context, logger *log.Logger) (accessToken string, err error)
+
+func (c *ConnectionCtxt) getAccessToken() string {
+	if c.tokenLock != nil {
+		c.tokenLock.Lock()
+		defer c.tokenLock.Unlock()
+	}
+	return c.AccessToken
+}
+
+// Replaces the rejected access token 'used' with a fresh one. If another request
+// has already done so in the meantime, the refresher is not called again.
+func (c *ConnectionCtxt) refreshAccessToken(ctxt context.Context, used string, logger *log.Logger) error {
+	if c.tokenLock != nil {
+		c.tokenLock.Lock()
+		defer c.tokenLock.Unlock()
+	}
+	if c.AccessToken != used {
+		return nil
+	}
+	token, err := c.TokenRefresher(ctxt, logger)
+	if err != nil {
+		return err
+	}
+	c.AccessToken = token
+	logger.Debug("refreshed access token")
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Max. number of idle (keep-alive) connections kept per host. Needs to be
+// large enough for concurrent uploads to reuse their connections.
+const DEF_MAX_IDLE_CONNS_PER_HOST = 16
+
+// TransportConfig defines how connections to a deployment are established.
+type TransportConfig struct {
+	// PEM encoded CA certificates to trust in addition to the system ones
+	CAFile string
+	// PEM encoded client certificate and key for mutual TLS
+	ClientCertFile string
+	ClientKeyFile  string
+	// Proxy to use for all requests. Defaults to the HTTP(S)_PROXY environment variables
+	ProxyURL string
+	// Do not verify the server's certificate. ONLY use for development clusters
+	InsecureSkipVerify bool
+	// Only use HTTP/1.1
+	DisableHTTP2 bool
+}
+
+// NewTransport returns a transport configured according to 'cfg'. It is meant to be
+// created once and shared by all adapters to benefit from connection reuse.
+func NewTransport(cfg *TransportConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = DEF_MAX_IDLE_CONNS_PER_HOST
+	if cfg == nil {
+		return t, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file '%s' - %v", cfg.CAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in CA file '%s'", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key need to be provided together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate - %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	t.TLSClientConfig = tlsConfig
+
+	if cfg.ProxyURL != "" {
+		proxy, err := url.Parse(cfg.ProxyURL)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("proxy '%s' is not a valid URL", cfg.ProxyURL)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	if cfg.DisableHTTP2 {
+		t.ForceAttemptHTTP2 = false
+		// a non-nil, empty map disables HTTP/2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	log "go.uber.org/zap"
+)
+
+func TestTransportWithCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	untrusted, _ := NewTransport(nil)
+	adpt := RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Transport: untrusted})
+	if _, err := adpt.Get(context.Background(), "/", log.NewNop()); err == nil {
+		t.Fatalf("Expected request to fail without trusted CA")
+	}
+
+	trusted, err := NewTransport(&TransportConfig{CAFile: caFile})
+	if err != nil {
+		t.Fatalf("NewTransport - %v", err)
+	}
+	adpt = RestAdapter(ConnectionCtxt{URL: srv.URL, TimeoutSec: 5, Transport: trusted})
+	if _, err := adpt.Get(context.Background(), "/", log.NewNop()); err != nil {
+		t.Fatalf("Expected request to succeed with trusted CA, but got %v", err)
+	}
+}
+
+func TestTransportRejectsIncompleteClientCert(t *testing.T) {
+	if _, err := NewTransport(&TransportConfig{ClientCertFile: "cert.pem"}); err == nil {
+		t.Fatalf("Expected error for missing client key")
+	}
+}
@@ -28,6 +28,7 @@ type ResponseHandler func(response *http.Response, path string, logger *log.Logg
 
 type Adapter interface {
 	Head(ctxt context.Context, path string, headers *map[string]string, logger *log.Logger) (Payload, error)
+	Options(ctxt context.Context, path string, headers *map[string]string, logger *log.Logger) (Payload, error)
 	Get(ctxt context.Context, path string, logger *log.Logger) (Payload, error)
 	Get2(ctxt context.Context, path string, headers *map[string]string, respHandler ResponseHandler, logger *log.Logger) error
 	Post(ctxt context.Context, path string, body io.Reader, length int64, headers *map[string]string, logger *log.Logger) (Payload, error)
@@ -35,6 +36,7 @@ type Adapter interface {
 	Put(ctxt context.Context, path string, body io.Reader, length int64, headers *map[string]string, logger *log.Logger) (Payload, error)
 	Patch(ctxt context.Context, path string, body io.Reader, length int64, headers *map[string]string, logger *log.Logger) (Payload, error)
 	Delete(ctxt context.Context, path string, logger *log.Logger) (Payload, error)
+	Delete2(ctxt context.Context, path string, headers *map[string]string, logger *log.Logger) (Payload, error)
 	SetUrl(url string)
 	GetPath(url string) (path string, err error)
 }
@@ -18,12 +18,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	api "github.com/reinventingscience/ivcap-core-api/http/artifact"
 
@@ -69,6 +72,27 @@ func ListArtifactsRaw(ctxt context.Context, cmd *ListArtifactRequest, adpt *adap
 	return (*adpt).Get(ctxt, path, logger)
 }
 
+// ListAllArtifacts returns all artifacts, following the 'next' links of the paged list
+func ListAllArtifacts(ctxt context.Context, adpt *adapter.Adapter, logger *log.Logger) ([]*api.ArtifactListItemResponseBody, error) {
+	list, err := ListArtifacts(ctxt, &ListArtifactRequest{}, adpt, logger)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := list.Artifacts
+	for list.Links != nil && list.Links.Next != nil && *list.Links.Next != "" {
+		pyld, err := (*adpt).Get(ctxt, *list.Links.Next, logger)
+		if err != nil {
+			return nil, err
+		}
+		list = &api.ListResponseBody{}
+		if err = pyld.AsType(list); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, list.Artifacts...)
+	}
+	return artifacts, nil
+}
+
 // /**** CREATE ****/
 
 type CreateArtifactRequest struct {
@@ -104,6 +128,7 @@ func UploadArtifact(
 	size int64,
 	offset int64,
 	chunkSize int64,
+	checksum string,
 	path string,
 	adpt *adapter.Adapter,
 	silent bool,
@@ -120,44 +145,250 @@ func UploadArtifact(
 
 	if size < 0 {
 		// unknown size, just uploading whatever is in the reader
-		return uploadUnknownSize(ctxt, reader, offset, chunkSize, path, adpt, logger)
+		return uploadUnknownSize(ctxt, reader, offset, chunkSize, checksum, path, adpt, logger)
+	}
+
+	if !silent {
+		reader = AddProgressBar("... uploading file", size-offset, reader)
+		defer fmt.Printf("\n") // To move past progress bar
 	}
+	return patchChunks(ctxt, reader, size, offset, chunkSize, checksum, path, adpt, logger)
+}
 
+// SupportsConcatenation returns true if the deployment lists the tus 'concatenation'
+// extension in its reply to an OPTIONS request to the artifact creation endpoint.
+func SupportsConcatenation(ctxt context.Context, adpt *adapter.Adapter, logger *log.Logger) bool {
+	h := map[string]string{"Tus-Resumable": "1.0.0"}
+	pyld, err := (*adpt).Options(ctxt, artifactPath(nil, adpt), &h, logger)
+	if err != nil {
+		logger.Debug("cannot get supported tus extensions", log.Error(err))
+		return false
+	}
+	for _, ext := range strings.Split(pyld.Header("Tus-Extension"), ",") {
+		if strings.TrimSpace(ext) == "concatenation" {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadArtifactParallel splits the 'size' bytes in 'reader' into 'parallel' partial
+// uploads, which are created at the artifact creation endpoint and sent concurrently.
+// The artifact described by 'cmd' is then created as the concatenation of these parts
+// (tus 'concatenation' extension). Check SupportsConcatenation before calling it.
+func UploadArtifactParallel(
+	ctxt context.Context,
+	cmd *CreateArtifactRequest,
+	contentType string,
+	reader io.ReaderAt,
+	size int64,
+	parallel int,
+	chunkSize int64,
+	checksum string,
+	adpt *adapter.Adapter,
+	silent bool,
+	logger *log.Logger,
+) (artifact *api.UploadResponseBody, err error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("parallel upload needs content of known size")
+	}
+	if size < int64(parallel) {
+		parallel = int(size)
+	}
+	partSize := (size + int64(parallel) - 1) / int64(parallel)
+	partCount := int((size + partSize - 1) / partSize)
+	var bar io.Writer = ioutil.Discard
+	if !silent {
+		bar = GetProgressBar("... uploading file", size)
+		defer fmt.Printf("\n") // To move past progress bar
+	}
+
+	// The first failing part cancels all others
+	pctxt, cancel := context.WithCancel(ctxt)
+	defer cancel()
+	var errOnce sync.Once
+	parts := make([]string, partCount)
+	var wg sync.WaitGroup
+	for i := 0; i < partCount; i++ {
+		off := int64(i) * partSize
+		psize := partSize
+		if off+psize > size {
+			psize = size - off
+		}
+		wg.Add(1)
+		go func(i int, off int64, psize int64) {
+			defer wg.Done()
+			r := io.TeeReader(io.NewSectionReader(reader, off, psize), bar)
+			partURL, partPath, perr := createPartialUpload(pctxt, psize, adpt, logger)
+			if perr == nil {
+				perr = patchChunks(pctxt, r, psize, 0, chunkSize, checksum, partPath, adpt, logger)
+			}
+			if perr != nil {
+				errOnce.Do(func() {
+					err = fmt.Errorf("while uploading part %d of %d - %w", i+1, partCount, perr)
+					cancel()
+				})
+				return
+			}
+			logger.Debug("uploaded part", log.Int("part", i+1), log.String("url", partURL))
+			parts[i] = partURL
+		}(i, off, psize)
+	}
+	wg.Wait()
+	if err != nil {
+		return
+	}
+
+	h := map[string]string{
+		"Upload-Concat": "final;" + strings.Join(parts, " "),
+		"Tus-Resumable": "1.0.0",
+		"Content-Type":  contentType,
+	}
+	if cmd.Name != "" {
+		h["X-Name"] = BaseEncode(cmd.Name)
+	}
+	if cmd.Collection != "" {
+		h["X-Collection"] = BaseEncode(cmd.Collection)
+	}
+	pyld, err := (*adpt).Post(ctxt, artifactPath(nil, adpt), nil, 0, &h, logger)
+	if err != nil {
+		return nil, fmt.Errorf("while concatenating %d parts - %w", partCount, err)
+	}
+	artifact = &api.UploadResponseBody{}
+	if err = pyld.AsType(artifact); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Creates a partial upload of 'size' bytes at the artifact creation endpoint and
+// returns its URL as well as its path
+func createPartialUpload(
+	ctxt context.Context,
+	size int64,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+) (location string, path string, err error) {
+	h := map[string]string{
+		"Upload-Concat": "partial",
+		"Upload-Length": fmt.Sprintf("%d", size),
+		"Tus-Resumable": "1.0.0",
+	}
+	pyld, err := (*adpt).Post(ctxt, artifactPath(nil, adpt), nil, 0, &h, logger)
+	if err != nil {
+		return
+	}
+	location = pyld.Header("Location")
+	if location == "" {
+		err = fmt.Errorf("missing 'Location' header for partial upload")
+		return
+	}
+	if strings.HasPrefix(location, "/") {
+		return location, location, nil
+	}
+	path, err = (*adpt).GetPath(location)
+	return
+}
+
+// Sends the content of 'reader' in chunks of 'chunkSize' as PATCH requests to 'path',
+// starting at 'offset' of an upload of 'size' bytes.
+func patchChunks(
+	ctxt context.Context,
+	reader io.Reader,
+	size int64,
+	offset int64,
+	chunkSize int64,
+	checksum string,
+	path string,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+) (err error) {
+	if chunkSize == 0 {
+		return fmt.Errorf("chunk size needs to be positive, or negative for no chunking")
+	}
 	remaining := size - offset
 	fragSize := chunkSize
 	if fragSize < 0 {
 		fragSize = remaining // no chunking
 	}
-	if !silent {
-		reader = AddProgressBar("... uploading file", remaining, reader)
+	// Chunks are buffered, so that the adapter can re-send them if a request fails
+	var buf []byte
+	if chunkSize > 0 {
+		buf = make([]byte, fragSize)
 	}
-	// var pyld adapter.Payload
 	for remaining > 0 {
 		psize := remaining
 		if psize > fragSize {
 			psize = fragSize
 		}
 		off := size - remaining
-		r := &io.LimitedReader{R: reader, N: psize}
-		h := map[string]string{
-			"Content-Type":  "application/offset+octet-stream",
-			"Upload-Offset": fmt.Sprintf("%d", off),
-			"Tus-Resumable": "1.0.0",
-		}
-		// var pyld adapter.Payload
-		_, err = (*adpt).Patch(context.Background(), path, r, psize, &h, logger)
-		if err != nil {
-			if !silent {
-				fmt.Printf("\n") // To move past progress bar
+		if buf != nil {
+			n, rerr := io.ReadFull(reader, buf[:psize])
+			if n == 0 {
+				if rerr == io.EOF {
+					rerr = io.ErrUnexpectedEOF
+				}
+				return rerr
+			}
+			if _, err = patchChunk(ctxt, path, buf[:n], off, checksum, nil, adpt, logger); err != nil {
+				return
+			}
+			remaining -= int64(n)
+		} else {
+			// not buffered, so there is no checksum to send
+			r := &io.LimitedReader{R: TransferReader(ctxt, reader), N: psize}
+			h := map[string]string{
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": fmt.Sprintf("%d", off),
+				"Tus-Resumable": "1.0.0",
+			}
+			if _, err = (*adpt).Patch(ctxt, path, r, psize, &h, logger); err != nil {
+				return
 			}
+			remaining -= psize - r.N
+		}
+	}
+	return
+}
+
+// Sends 'data' as the content at 'offset' of the upload at 'path'. If 'checksum' names
+// an algorithm, the chunk's digest is sent along (tus 'checksum' extension) and the
+// chunk is sent again if the server reports that it got corrupted on the way.
+func patchChunk(
+	ctxt context.Context,
+	path string,
+	data []byte,
+	offset int64,
+	checksum string,
+	headers map[string]string,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+) (pyld adapter.Payload, err error) {
+	h := map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": fmt.Sprintf("%d", offset),
+		"Tus-Resumable": "1.0.0",
+	}
+	for k, v := range headers {
+		h[k] = v
+	}
+	if checksum != "" && checksum != CHECKSUM_NONE {
+		if h["Upload-Checksum"], err = ChunkChecksum(checksum, data); err != nil {
 			return
 		}
-		remaining -= psize - r.N
 	}
-	if !silent {
-		fmt.Printf("\n") // To move past progress bar
+	for attempt := 0; ; attempt++ {
+		// counted when sent, so that chunks sent again count as well
+		body := TransferReader(ctxt, bytes.NewReader(data))
+		pyld, err = (*adpt).Patch(ctxt, path, body, int64(len(data)), &h, logger)
+		var apiErr *adapter.ApiError
+		if err == nil || attempt >= MAX_CHECKSUM_RETRIES ||
+			!errors.As(err, &apiErr) || apiErr.StatusCode != TUS_CHECKSUM_MISMATCH {
+			return
+		}
+		logger.Info("chunk checksum mismatch, sending again", log.Int64("offset", offset), log.Int("attempt", attempt+1))
+		adapter.CountRetry(ctxt)
 	}
-	return
 }
 
 func uploadUnknownSize(
@@ -165,33 +396,39 @@ func uploadUnknownSize(
 	reader io.Reader,
 	offset int64,
 	chunkSize int64,
+	checksum string,
 	path string,
 	adpt *adapter.Adapter,
 	logger *log.Logger,
 ) (err error) {
+	if chunkSize <= 0 {
+		return fmt.Errorf("content of unknown size needs a positive chunk size")
+	}
 	off := offset
 	p := make([]byte, chunkSize)
 	for {
-		h := map[string]string{
-			"Content-Type":  "application/offset+octet-stream",
-			"Upload-Offset": fmt.Sprintf("%d", off),
-			"Tus-Resumable": "1.0.0",
-		}
-
 		var n int
-		if n, err = reader.Read(p); err != nil || n == 0 {
+		// fill whole chunks, even if the reader returns less at a time
+		if n, err = io.ReadFull(reader, p); err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil || n == 0 {
 			if err != nil && err != io.EOF {
 				return
 			}
 			// need to inform about size
-			h["Upload-Length"] = fmt.Sprintf("%d", off)
-			_, err = (*adpt).Patch(context.Background(), path, nil, 0, &h, logger)
+			h := map[string]string{
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": fmt.Sprintf("%d", off),
+				"Upload-Length": fmt.Sprintf("%d", off),
+				"Tus-Resumable": "1.0.0",
+			}
+			_, err = (*adpt).Patch(ctxt, path, nil, 0, &h, logger)
 			return
 		}
-		r := bytes.NewReader(p[:n])
-		h["Upload-Defer-Length"] = "1"
+		h := map[string]string{"Upload-Defer-Length": "1"}
 		var pyld adapter.Payload
-		pyld, err = (*adpt).Patch(context.Background(), path, r, int64(n), &h, logger)
+		pyld, err = patchChunk(ctxt, path, p[:n], off, checksum, h, adpt, logger)
 		if err != nil {
 			return
 		}
@@ -215,7 +452,7 @@ func AddProgressBar(description string, size int64, reader io.Reader) io.Reader
 	return io.TeeReader(reader, bar)
 }
 
-func GetProgressBar(description string, size int64) io.Writer {
+func GetProgressBar(description string, size int64) *progressbar.ProgressBar {
 	return progressbar.NewOptions64(size,
 		progressbar.OptionSetWriter(ansi.NewAnsiStderr()),
 		progressbar.OptionEnableColorCodes(true),
@@ -301,6 +538,24 @@ func ReadArtifactRaw(ctxt context.Context, cmd *ReadArtifactRequest, adpt *adapt
 	return (*adpt).Get(ctxt, path, logger)
 }
 
+/**** DELETE ****/
+
+// DeleteArtifact removes an artifact together with its content
+func DeleteArtifact(ctxt context.Context, artifactID string, adpt *adapter.Adapter, logger *log.Logger) (adapter.Payload, error) {
+	path := artifactPath(&artifactID, adpt)
+	return (*adpt).Delete(ctxt, path, logger)
+}
+
+// TerminateUpload stops the unfinished upload at 'path' and lets the deployment
+// free the content received so far (tus 'termination' extension)
+func TerminateUpload(ctxt context.Context, path string, adpt *adapter.Adapter, logger *log.Logger) error {
+	h := map[string]string{
+		"Tus-Resumable": "1.0.0",
+	}
+	_, err := (*adpt).Delete2(ctxt, path, &h, logger)
+	return err
+}
+
 /**** COLLECTION ****/
 
 func AddArtifactToCollection(
@@ -345,6 +600,33 @@ func AddArtifactMeta(
 
 /**** UTILS ****/
 
+// Schema of the metadata record holding the path of an artifact's content relative
+// to the directory it was uploaded from
+const ARTIFACT_PATH_SCHEMA = "urn:ivcap:schema:artifact.path.1"
+
+type ArtifactPath struct {
+	Schema     string `json:"$schema"`
+	Path       string `json:"path"`                 // always uses '/' as separator
+	Collection string `json:"collection,omitempty"` // collection the directory was uploaded to
+}
+
+// AddArtifactPath records the relative 'path' of the file an artifact was uploaded from,
+// and the 'collection' it was uploaded to, as its metadata
+func AddArtifactPath(
+	ctxt context.Context,
+	artifactID string,
+	path string,
+	collection string,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+) (adapter.Payload, error) {
+	b, err := json.Marshal(&ArtifactPath{Schema: ARTIFACT_PATH_SCHEMA, Path: path, Collection: collection})
+	if err != nil {
+		return nil, err
+	}
+	return AddArtifactMeta(ctxt, artifactID, ARTIFACT_PATH_SCHEMA, bytes.NewReader(b), int64(len(b)), adpt, logger)
+}
+
 func artifactPath(id *string, adpt *adapter.Adapter) string {
 	path := "/1/artifacts"
 	if id != nil {
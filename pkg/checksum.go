@@ -0,0 +1,98 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+
+	"github.com/reinventingscience/ivcap-cli/pkg/adapter"
+
+	log "go.uber.org/zap"
+)
+
+const (
+	CHECKSUM_SHA256 = "sha256"
+	CHECKSUM_MD5    = "md5"
+	CHECKSUM_NONE   = "none"
+)
+
+// Status code of a tus server rejecting a chunk whose 'Upload-Checksum' doesn't match
+const TUS_CHECKSUM_MISMATCH = 460
+
+// How often a chunk is sent again after a checksum mismatch
+const MAX_CHECKSUM_RETRIES = 3
+
+// Schema of the metadata record holding the digest of an artifact's content
+const ARTIFACT_CHECKSUM_SCHEMA = "urn:ivcap:schema:artifact.checksum.1"
+
+type ArtifactChecksum struct {
+	Schema    string `json:"$schema"`
+	Algorithm string `json:"algorithm"`
+	Digest    string `json:"digest"` // hex encoded
+	Size      int64  `json:"size"`
+}
+
+// NewChecksumHash returns a hash for 'algorithm' (sha256, md5)
+func NewChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case CHECKSUM_SHA256:
+		return sha256.New(), nil
+	case CHECKSUM_MD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm '%s'", algorithm)
+	}
+}
+
+// ChunkChecksum returns the value of the tus 'Upload-Checksum' header for 'data'
+func ChunkChecksum(algorithm string, data []byte) (string, error) {
+	h, err := NewChecksumHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	h.Write(data)
+	return fmt.Sprintf("%s %s", algorithm, base64.StdEncoding.EncodeToString(h.Sum(nil))), nil
+}
+
+// AddArtifactChecksum records the 'digest' of the 'size' bytes of content of an artifact as its metadata
+func AddArtifactChecksum(
+	ctxt context.Context,
+	artifactID string,
+	algorithm string,
+	digest []byte,
+	size int64,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+) (adapter.Payload, error) {
+	cs := ArtifactChecksum{
+		Schema:    ARTIFACT_CHECKSUM_SCHEMA,
+		Algorithm: algorithm,
+		Digest:    hex.EncodeToString(digest),
+		Size:      size,
+	}
+	b, err := json.Marshal(&cs)
+	if err != nil {
+		return nil, err
+	}
+	return AddArtifactMeta(ctxt, artifactID, ARTIFACT_CHECKSUM_SCHEMA, bytes.NewReader(b), int64(len(b)), adpt, logger)
+}
@@ -0,0 +1,434 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/reinventingscience/ivcap-cli/pkg/adapter"
+
+	log "go.uber.org/zap"
+)
+
+// Content is downloaded into a file with this suffix, and renamed once complete
+const DOWNLOAD_PART_SUFFIX = ".part"
+
+// Progress of a download in byte ranges is kept in a file with this suffix
+const DOWNLOAD_RANGES_SUFFIX = ".part.ranges"
+
+// The content a sequential download belongs to is kept in a file with this suffix
+const DOWNLOAD_INFO_SUFFIX = ".part.info"
+
+// How often a download continues after the connection dropped
+const DOWNLOAD_MAX_RESUMES = 3
+
+// Identifies the content a partial download belongs to
+type downloadSource struct {
+	ArtifactID string `json:"artifact-id"`
+	ETag       string `json:"etag,omitempty"`
+}
+
+type downloadState struct {
+	downloadSource
+	Size   int64            `json:"size"`
+	Ranges []*downloadRange `json:"ranges"`
+}
+
+type downloadRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`  // exclusive
+	Done  int64 `json:"done"` // bytes already written, starting at 'Start'
+}
+
+// Marks errors while reading the response body, after which the download can continue
+type interruptedError struct {
+	err error
+}
+
+func (e *interruptedError) Error() string { return fmt.Sprintf("download interrupted - %v", e.err) }
+
+func (e *interruptedError) Unwrap() error { return e.err }
+
+type interruptibleReader struct {
+	r io.Reader
+}
+
+func (r *interruptibleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &interruptedError{err}
+	}
+	return n, err
+}
+
+// Returned if the content changed while it was downloaded in byte ranges
+var errContentChanged = errors.New("content changed while downloading, download it again")
+
+// DownloadArtifactToFile downloads the content of 'artifactID' at 'path' into 'fileName'.
+// The content is first written to 'fileName.part', so that calling it again after an
+// interruption continues where the last call stopped. The artifact and the ETag of its
+// content are kept alongside, so that content left over from another artifact, or which
+// changed since, is downloaded again from the start. If 'size' is known (> 0) the downloaded content
+// is checked against it and, if 'parallel' > 1, fetched as byte ranges in parallel.
+// Deployments may report a size of 0 for artifacts whose size isn't known yet, so
+// that is treated as unknown as well.
+func DownloadArtifactToFile(
+	ctxt context.Context,
+	artifactID string,
+	path string,
+	fileName string,
+	size int64,
+	parallel int,
+	adpt *adapter.Adapter,
+	silent bool,
+	logger *log.Logger,
+) (err error) {
+	if size <= 0 {
+		size = -1
+	}
+	partName := fileName + DOWNLOAD_PART_SUFFIX
+	rangesName := fileName + DOWNLOAD_RANGES_SUFFIX
+	infoName := fileName + DOWNLOAD_INFO_SUFFIX
+	var bar *progressbar.ProgressBar
+	if !silent {
+		bar = GetProgressBar("... downloading file", size)
+		defer fmt.Fprintf(os.Stderr, "\n") // To move past progress bar
+	}
+
+	_, partErr := os.Stat(partName)
+	if _, serr := os.Stat(rangesName); serr == nil || (parallel > 1 && size > 0 && partErr != nil) {
+		src := downloadSource{ArtifactID: artifactID}
+		if err = downloadRanges(ctxt, src, path, partName, rangesName, size, parallel, adpt, bar, logger); errors.Is(err, errContentChanged) {
+			os.Remove(rangesName)
+			os.Remove(partName)
+		}
+	} else {
+		err = downloadSequentially(ctxt, artifactID, path, partName, infoName, size, adpt, bar, logger)
+	}
+	if err != nil {
+		return
+	}
+	if size >= 0 {
+		info, err := os.Stat(partName)
+		if err != nil {
+			return err
+		}
+		if info.Size() != size {
+			os.Remove(partName)
+			os.Remove(infoName)
+			return fmt.Errorf("downloaded %d bytes, but artifact has %d", info.Size(), size)
+		}
+	}
+	os.Remove(rangesName)
+	os.Remove(infoName)
+	return os.Rename(partName, fileName)
+}
+
+// Appends the content of 'artifactID' at 'path' to what's already in 'partName', as long
+// as 'infoName' confirms that it holds the start of the same content
+func downloadSequentially(
+	ctxt context.Context,
+	artifactID string,
+	path string,
+	partName string,
+	infoName string,
+	size int64,
+	adpt *adapter.Adapter,
+	bar *progressbar.ProgressBar,
+	logger *log.Logger,
+) error {
+	file, err := os.OpenFile(partName, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	src := &downloadSource{}
+	if b, rerr := ioutil.ReadFile(infoName); rerr == nil {
+		json.Unmarshal(b, src)
+	}
+	if src.ArtifactID != artifactID {
+		// whatever is in 'partName' isn't from this artifact
+		src = &downloadSource{ArtifactID: artifactID}
+		if err = truncate(file); err != nil {
+			return err
+		}
+	}
+	save := func() error {
+		b, _ := json.Marshal(src)
+		return ioutil.WriteFile(infoName, b, 0644)
+	}
+	if err = save(); err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		offset, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if size >= 0 && offset > size {
+			// not from this artifact, start again
+			offset = 0
+			if err = truncate(file); err != nil {
+				return err
+			}
+		}
+		if size >= 0 && offset == size {
+			return nil
+		}
+		h := map[string]string{}
+		if offset > 0 {
+			h["Range"] = fmt.Sprintf("bytes=%d-", offset)
+			if src.ETag != "" {
+				// content which changed since is sent in full
+				h["If-Range"] = src.ETag
+			}
+			logger.Debug("resuming download", log.Int64("offset", offset))
+		}
+		if bar != nil {
+			bar.Set64(offset)
+		}
+		handler := func(resp *http.Response, path string, logger *log.Logger) error {
+			switch {
+			case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && size < 0:
+				return nil // nothing left to download
+			case resp.StatusCode >= 300:
+				return processDownloadError(resp, path, logger)
+			case resp.StatusCode != http.StatusPartialContent && offset > 0:
+				// server ignored the range, so we get everything again
+				if bar != nil {
+					bar.Set64(0)
+				}
+				if err := truncate(file); err != nil {
+					return err
+				}
+			}
+			if etag := resp.Header.Get("ETag"); etag != src.ETag {
+				src.ETag = etag
+				if err := save(); err != nil {
+					return err
+				}
+			}
+			var w io.Writer = file
+			if bar != nil {
+				w = io.MultiWriter(file, bar)
+			}
+			_, err := io.Copy(w, &interruptibleReader{TransferReader(ctxt, resp.Body)})
+			return err
+		}
+		err = (*adpt).Get2(ctxt, path, &h, handler, logger)
+		var ie *interruptedError
+		if err == nil || attempt >= DOWNLOAD_MAX_RESUMES || ctxt.Err() != nil || !errors.As(err, &ie) {
+			return err
+		}
+		logger.Info("download interrupted, resuming", log.Error(err), log.Int("attempt", attempt+1))
+		adapter.CountRetry(ctxt)
+	}
+}
+
+// Downloads the content of 'src' at 'path' as 'parallel' byte ranges, which are written
+// concurrently into 'partName'. The progress of every range is kept in 'rangesName'.
+func downloadRanges(
+	ctxt context.Context,
+	src downloadSource,
+	path string,
+	partName string,
+	rangesName string,
+	size int64,
+	parallel int,
+	adpt *adapter.Adapter,
+	bar *progressbar.ProgressBar,
+	logger *log.Logger,
+) (err error) {
+	state := &downloadState{}
+	if b, rerr := ioutil.ReadFile(rangesName); rerr == nil {
+		if json.Unmarshal(b, state) != nil || state.Size != size || state.ArtifactID != src.ArtifactID {
+			logger.Info("ignoring progress of earlier download", log.String("file", rangesName))
+			state = &downloadState{}
+		}
+	}
+	file, err := os.OpenFile(partName, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if state.Ranges == nil {
+		if parallel < 1 {
+			parallel = 1
+		}
+		state.downloadSource = src
+		state.Size = size
+		rsize := (size + int64(parallel) - 1) / int64(parallel)
+		for start := int64(0); start < size; start += rsize {
+			end := start + rsize
+			if end > size {
+				end = size
+			}
+			state.Ranges = append(state.Ranges, &downloadRange{Start: start, End: end})
+		}
+		if err = file.Truncate(size); err != nil {
+			return
+		}
+	}
+
+	var lock sync.Mutex // guards 'state'
+	save := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		b, _ := json.Marshal(state)
+		if werr := ioutil.WriteFile(rangesName, b, 0644); werr != nil {
+			logger.Warn("cannot save download progress", log.Error(werr))
+		}
+	}
+	save()
+	defer save()
+
+	// The first failing range cancels all others
+	ctxt, cancel := context.WithCancel(ctxt)
+	defer cancel()
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for _, r := range state.Ranges {
+		if bar != nil {
+			bar.Add64(r.Done)
+		}
+		if r.Start+r.Done >= r.End {
+			continue
+		}
+		wg.Add(1)
+		go func(r *downloadRange) {
+			defer wg.Done()
+			w := &rangeWriter{file: file, r: r, lock: &lock, bar: bar}
+			if rerr := fetchRange(ctxt, path, w, &state.downloadSource, adpt, logger); rerr != nil {
+				errOnce.Do(func() {
+					err = fmt.Errorf("while downloading bytes %d-%d - %w", r.Start, r.End-1, rerr)
+					cancel()
+				})
+				return
+			}
+			save()
+		}(r)
+	}
+	wg.Wait()
+	return
+}
+
+// Downloads the range of 'w', making sure that the content still has the ETag recorded in 'src'.
+// The first range to complete records it if it isn't known yet.
+func fetchRange(
+	ctxt context.Context,
+	path string,
+	w *rangeWriter,
+	src *downloadSource,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+) error {
+	for attempt := 0; ; attempt++ {
+		h := map[string]string{
+			"Range": fmt.Sprintf("bytes=%d-%d", w.offset(), w.r.End-1),
+		}
+		w.lock.Lock()
+		etag := src.ETag
+		w.lock.Unlock()
+		if etag != "" {
+			h["If-Range"] = etag
+		}
+		handler := func(resp *http.Response, path string, logger *log.Logger) error {
+			if resp.StatusCode >= 300 {
+				return processDownloadError(resp, path, logger)
+			}
+			if resp.StatusCode != http.StatusPartialContent {
+				if etag != "" {
+					return errContentChanged
+				}
+				return fmt.Errorf("server doesn't support range requests, download without parallel ranges")
+			}
+			w.lock.Lock()
+			if src.ETag == "" {
+				src.ETag = resp.Header.Get("ETag")
+			} else if etag == "" && resp.Header.Get("ETag") != src.ETag {
+				// another range recorded a different ETag before this one got sent
+				w.lock.Unlock()
+				return errContentChanged
+			}
+			w.lock.Unlock()
+			_, err := io.Copy(w, &interruptibleReader{TransferReader(ctxt, resp.Body)})
+			return err
+		}
+		err := (*adpt).Get2(ctxt, path, &h, handler, logger)
+		var ie *interruptedError
+		if err == nil || attempt >= DOWNLOAD_MAX_RESUMES || ctxt.Err() != nil || !errors.As(err, &ie) {
+			return err
+		}
+		logger.Info("download interrupted, resuming", log.Error(err), log.Int("attempt", attempt+1))
+		adapter.CountRetry(ctxt)
+	}
+}
+
+// Writes into the part of a file covered by a download range and keeps track of its progress
+type rangeWriter struct {
+	file *os.File
+	r    *downloadRange
+	lock *sync.Mutex
+	bar  *progressbar.ProgressBar
+}
+
+func (w *rangeWriter) offset() int64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.r.Start + w.r.Done
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	off := w.offset()
+	if max := w.r.End - off; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := w.file.WriteAt(p, off)
+	w.lock.Lock()
+	w.r.Done += int64(n)
+	w.lock.Unlock()
+	if w.bar != nil {
+		w.bar.Add(n)
+	}
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
+
+func processDownloadError(resp *http.Response, path string, logger *log.Logger) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	return adapter.ProcessErrorResponse(resp, path, adapter.ToPayload(body, resp, logger), logger)
+}
+
+func truncate(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return err
+}
@@ -91,6 +91,33 @@ func ListMetadata(ctxt context.Context,
 	}
 }
 
+// ListAllMetadata returns all records matching 'entity' and 'schemaPrefix', following
+// the 'next' links of the returned pages
+func ListAllMetadata(ctxt context.Context,
+	entity string,
+	schemaPrefix string,
+	adpt *adapter.Adapter,
+	logger *log.Logger,
+) ([]*api.MetadataListItemRTResponseBody, error) {
+	list, _, err := ListMetadata(ctxt, entity, schemaPrefix, nil, adpt, logger)
+	if err != nil {
+		return nil, err
+	}
+	records := list.Records
+	for list.Links != nil && list.Links.Next != nil && *list.Links.Next != "" {
+		pyld, err := (*adpt).Get(ctxt, *list.Links.Next, logger)
+		if err != nil {
+			return nil, err
+		}
+		list = &api.ListResponseBody{}
+		if err = pyld.AsType(list); err != nil {
+			return nil, err
+		}
+		records = append(records, list.Records...)
+	}
+	return records, nil
+}
+
 /**** UTILS ****/
 
 func metadataPath(id *string, adpt *adapter.Adapter) string {
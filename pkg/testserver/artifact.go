@@ -0,0 +1,510 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testserver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/reinventingscience/ivcap-core-api/http/artifact"
+)
+
+// Status code of a tus server rejecting a chunk whose 'Upload-Checksum' doesn't match
+const TUS_CHECKSUM_MISMATCH = 460
+
+const (
+	ARTIFACT_PENDING   = "pending"
+	ARTIFACT_AVAILABLE = "available"
+)
+
+type artifact struct {
+	id          string
+	name        string
+	mimeType    string
+	size        int64 // -1 if not known yet
+	collections []string
+	data        []byte
+	terminated  bool // upload was stopped by the client
+}
+
+func (a *artifact) status() string {
+	if a.size >= 0 && int64(len(a.data)) >= a.size {
+		return ARTIFACT_AVAILABLE
+	}
+	return ARTIFACT_PENDING
+}
+
+// Artifact returns the content uploaded so far for artifact 'id'
+func (s *Server) Artifact(id string) (data []byte, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if a, ok := s.artifacts[id]; ok {
+		return append([]byte{}, a.data...), true
+	}
+	return nil, false
+}
+
+// CorruptChunks makes the server reject the next 'n' chunks carrying an
+// 'Upload-Checksum', as if they got corrupted on the way
+func (s *Server) CorruptChunks(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.corruptChunks = n
+}
+
+// SlowDownloads makes the server pause for 'd' after sending the first byte of
+// artifact content, as if the connection was slow
+func (s *Server) SlowDownloads(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.downloadDelay = d
+}
+
+// DisableConcatenation makes the server stop offering the tus 'concatenation' extension
+func (s *Server) DisableConcatenation() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.noConcat = true
+}
+
+// Handles all requests to '/1/artifacts' with 'path' the remainder of the URL path
+func (s *Server) artifactHandler(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "" || path == "/" {
+		switch r.Method {
+		case "GET":
+			s.listArtifacts(w, r)
+		case "POST":
+			s.createArtifact(w, r)
+		case "OPTIONS":
+			extensions := "creation,creation-defer-length,checksum,termination"
+			if !s.noConcat {
+				extensions += ",concatenation"
+			}
+			w.Header().Set("Tus-Resumable", "1.0.0")
+			w.Header().Set("Tus-Version", "1.0.0")
+			w.Header().Set("Tus-Extension", extensions)
+			w.Header().Set("Tus-Checksum-Algorithm", "md5,sha1,sha256")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, r)
+		}
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if parts[0] == "partials" && len(parts) == 2 {
+		if p, ok := s.partials[parts[1]]; ok {
+			s.artifactData(w, r, p)
+		} else {
+			writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("partial upload '%s' not found", parts[1]))
+		}
+		return
+	}
+	a, ok := s.artifacts[parts[0]]
+	if !ok {
+		writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("artifact '%s' not found", parts[0]))
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, s.readArtifactBody(a))
+	case len(parts) == 1 && r.Method == "DELETE":
+		delete(s.artifacts, a.id)
+		for i, id := range s.artifactIDs {
+			if id == a.id {
+				s.artifactIDs = append(s.artifactIDs[:i], s.artifactIDs[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == "blob":
+		s.artifactData(w, r, a)
+	case len(parts) == 3 && parts[1] == ".collections":
+		s.artifactCollection(w, r, a, parts[2])
+	case len(parts) == 3 && parts[1] == ".metadata" && r.Method == "PUT":
+		schema, _ := url.PathUnescape(parts[2])
+		body, _ := ioutil.ReadAll(r.Body)
+		if rec, err := s.addMetadata(a.id, schema, body); err != nil {
+			writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+		} else {
+			writeJSON(w, http.StatusOK, map[string]string{"record-id": rec.id})
+		}
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) listArtifacts(w http.ResponseWriter, r *http.Request) {
+	ids, next := s.page(r, s.artifactIDs)
+	list := &api.ListResponseBody{
+		Artifacts: []*api.ArtifactListItemResponseBody{},
+		Links:     &api.NavTResponseBody{Self: s.selfLink(r.URL.RequestURI()), Next: next},
+	}
+	for _, id := range ids {
+		a := s.artifacts[id]
+		size := a.size
+		list.Artifacts = append(list.Artifacts, &api.ArtifactListItemResponseBody{
+			ID:       strp(a.id),
+			Name:     strp(a.name),
+			Status:   strp(a.status()),
+			Size:     &size,
+			MimeType: strp(a.mimeType),
+			Links:    &api.SelfTResponseBody{Self: s.selfLink("/1/artifacts/" + a.id)},
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Creates an artifact either as a tus upload ('Upload-Length' or 'Upload-Defer-Length'),
+// as the concatenation of partial uploads ('Upload-Concat: final;...'), or with the
+// content in the request body ('X-Content-Type', 'X-Content-Length'). Requests with
+// 'Upload-Concat: partial' create a partial upload instead.
+func (s *Server) createArtifact(w http.ResponseWriter, r *http.Request) {
+	concat := r.Header.Get("Upload-Concat")
+	if concat != "" && (s.noConcat || r.Header.Get("Tus-Resumable") == "") {
+		writeFault(w, http.StatusBadRequest, "bad_request", "unsupported 'Upload-Concat'")
+		return
+	}
+	if concat == "partial" {
+		s.createPartialUpload(w, r)
+		return
+	}
+	a := &artifact{id: s.newID("artifact"), size: -1}
+	if n, err := decodeHeader(r, "X-Name"); err == nil {
+		a.name = n
+	} else {
+		writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	if c, err := decodeHeader(r, "X-Collection"); err != nil {
+		writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	} else if c != "" {
+		a.collections = []string{c}
+	}
+	if strings.HasPrefix(concat, "final;") {
+		data, err := s.concatPartials(strings.TrimPrefix(concat, "final;"))
+		if err != nil {
+			writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		a.mimeType = r.Header.Get("Content-Type")
+		a.data, a.size = data, int64(len(data))
+	} else if concat != "" {
+		writeFault(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid 'Upload-Concat' - %s", concat))
+		return
+	} else if r.Header.Get("Tus-Resumable") != "" {
+		a.mimeType = r.Header.Get("Content-Type")
+		if l := r.Header.Get("Upload-Length"); l != "" {
+			size, err := strconv.ParseInt(l, 10, 64)
+			if err != nil || size < 0 {
+				writeFault(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid 'Upload-Length' - %s", l))
+				return
+			}
+			a.size = size
+		} else if r.Header.Get("Upload-Defer-Length") != "1" {
+			writeFault(w, http.StatusBadRequest, "bad_request", "missing 'Upload-Length'")
+			return
+		}
+	} else {
+		a.mimeType = r.Header.Get("X-Content-Type")
+		if l := r.Header.Get("X-Content-Length"); l != "" {
+			if size, err := strconv.ParseInt(l, 10, 64); err == nil && size >= 0 {
+				a.size = size
+			}
+		}
+		if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+			a.data = body
+			a.size = int64(len(body))
+		}
+	}
+	if a.mimeType == "" {
+		a.mimeType = "application/octet-stream"
+	}
+	s.artifacts[a.id] = a
+	s.artifactIDs = append(s.artifactIDs, a.id)
+
+	body := s.readArtifactBody(a)
+	w.Header().Set("Location", *body.Data.Self)
+	w.Header().Set("Tus-Resumable", "1.0.0")
+	writeJSON(w, http.StatusCreated, &api.UploadResponseBody{
+		ID:          body.ID,
+		Name:        body.Name,
+		Collections: body.Collections,
+		Data:        body.Data,
+		Status:      body.Status,
+		MimeType:    body.MimeType,
+		Size:        body.Size,
+		Account:     body.Account,
+		Links:       body.Links,
+	})
+}
+
+func (s *Server) readArtifactBody(a *artifact) *api.ReadResponseBody {
+	size := a.size
+	offset := int64(len(a.data))
+	self := s.selfLink("/1/artifacts/" + a.id)
+	collections := append([]string{}, a.collections...)
+	var meta []*api.MetadataTResponseBody
+	for _, id := range s.metadataIDs {
+		if m := s.metadata[id]; m.entity == a.id && m.revokedAt == nil {
+			meta = append(meta, &api.MetadataTResponseBody{Schema: strp(m.schema), Data: m.aspect})
+		}
+	}
+	return &api.ReadResponseBody{
+		ID:           strp(a.id),
+		Name:         strp(a.name),
+		Collections:  collections,
+		Data:         &api.SelfTResponseBody{Self: s.selfLink("/1/artifacts/" + a.id + "/blob")},
+		Status:       strp(a.status()),
+		MimeType:     strp(a.mimeType),
+		Size:         &size,
+		Metadata:     meta,
+		Account:      &api.RefTResponseBody{ID: strp(TEST_ACCOUNT_ID)},
+		Links:        &api.SelfTResponseBody{Self: self},
+		Location:     self,
+		TusResumable: strp("1.0.0"),
+		TusOffset:    &offset,
+	}
+}
+
+// Serves the content of an artifact (GET, supporting ranges), reports the
+// upload offset (HEAD), appends content to it (PATCH), or terminates the
+// upload (DELETE) following the tus protocol.
+func (s *Server) artifactData(w http.ResponseWriter, r *http.Request, a *artifact) {
+	if a.terminated {
+		writeFault(w, http.StatusGone, "gone", fmt.Sprintf("upload of artifact '%s' was terminated", a.id))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", a.mimeType)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(a.data)))
+		if s.downloadDelay > 0 {
+			w = &slowWriter{ResponseWriter: w, delay: s.downloadDelay}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(a.data))
+	case "HEAD":
+		w.Header().Set("Tus-Resumable", "1.0.0")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(a.data)))
+		if a.size >= 0 {
+			w.Header().Set("Upload-Length", strconv.FormatInt(a.size, 10))
+		} else {
+			w.Header().Set("Upload-Defer-Length", "1")
+		}
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		s.patchArtifact(w, r, a)
+	case "DELETE":
+		if r.Header.Get("Tus-Resumable") == "" {
+			writeFault(w, http.StatusPreconditionFailed, "bad_request", "missing 'Tus-Resumable'")
+			return
+		}
+		a.terminated = true
+		a.data = nil
+		w.Header().Set("Tus-Resumable", "1.0.0")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// Pauses for 'delay' after writing the first byte
+type slowWriter struct {
+	http.ResponseWriter
+	delay  time.Duration
+	paused bool
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	if w.paused || len(p) == 0 {
+		return w.ResponseWriter.Write(p)
+	}
+	n, err := w.ResponseWriter.Write(p[:1])
+	if err != nil {
+		return n, err
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	time.Sleep(w.delay)
+	w.paused = true
+	m, err := w.ResponseWriter.Write(p[1:])
+	return n + m, err
+}
+
+func (s *Server) patchArtifact(w http.ResponseWriter, r *http.Request, a *artifact) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/offset+octet-stream" {
+		writeFault(w, http.StatusUnsupportedMediaType, "bad_request", fmt.Sprintf("unsupported content type '%s'", ct))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		writeFault(w, http.StatusBadRequest, "bad_request", "missing or invalid 'Upload-Offset'")
+		return
+	}
+	if offset != int64(len(a.data)) {
+		writeFault(w, http.StatusConflict, "conflict",
+			fmt.Sprintf("'Upload-Offset' %d does not match current offset %d", offset, len(a.data)))
+		return
+	}
+	if l := r.Header.Get("Upload-Length"); l != "" && a.size < 0 {
+		if a.size, err = strconv.ParseInt(l, 10, 64); err != nil {
+			writeFault(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid 'Upload-Length' - %s", l))
+			return
+		}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	cs := r.Header.Get("Upload-Checksum")
+	if err != nil {
+		if cs == "" {
+			// keep whatever arrived, so that clients can resume interrupted uploads
+			a.data = append(a.data, body...)
+		}
+		return
+	}
+	if cs != "" {
+		if ok, err := s.verifyChecksum(cs, body); err != nil {
+			writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		} else if !ok {
+			writeFault(w, TUS_CHECKSUM_MISMATCH, "checksum_mismatch", "'Upload-Checksum' does not match content")
+			return
+		}
+	}
+	a.data = append(a.data, body...)
+	if a.size >= 0 && int64(len(a.data)) > a.size {
+		a.data = a.data[:offset]
+		writeFault(w, http.StatusRequestEntityTooLarge, "bad_request", "upload exceeds 'Upload-Length'")
+		return
+	}
+	w.Header().Set("Tus-Resumable", "1.0.0")
+	w.Header().Set("Upload-Offset", strconv.Itoa(len(a.data)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Creates a partial upload ('Upload-Concat: partial') of 'Upload-Length' bytes
+func (s *Server) createPartialUpload(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		writeFault(w, http.StatusBadRequest, "bad_request", "missing or invalid 'Upload-Length'")
+		return
+	}
+	p := &artifact{id: s.newID("partial"), size: size}
+	s.partials[p.id] = p
+	w.Header().Set("Location", *s.selfLink("/1/artifacts/partials/" + p.id))
+	w.Header().Set("Tus-Resumable", "1.0.0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Returns the content of the completed partial uploads listed by URL in 'refs', in
+// order, and frees them
+func (s *Server) concatPartials(refs string) ([]byte, error) {
+	var data []byte
+	var ids []string
+	for _, ref := range strings.Fields(refs) {
+		u, err := url.Parse(ref)
+		if err != nil || !strings.HasPrefix(u.Path, "/1/artifacts/partials/") {
+			return nil, fmt.Errorf("invalid partial upload '%s'", ref)
+		}
+		id := strings.TrimPrefix(u.Path, "/1/artifacts/partials/")
+		p, ok := s.partials[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown partial upload '%s'", ref)
+		}
+		if p.status() != ARTIFACT_AVAILABLE {
+			return nil, fmt.Errorf("partial upload '%s' is incomplete", ref)
+		}
+		data = append(data, p.data...)
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no partial uploads to concatenate")
+	}
+	for _, id := range ids {
+		delete(s.partials, id)
+	}
+	return data, nil
+}
+
+// Checks 'body' against an 'Upload-Checksum' header value, such as 'sha256 <base64 digest>'
+func (s *Server) verifyChecksum(checksum string, body []byte) (bool, error) {
+	algorithm, sum, _ := strings.Cut(checksum, " ")
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return false, fmt.Errorf("unsupported checksum algorithm '%s'", algorithm)
+	}
+	h.Write(body)
+	if s.corruptChunks > 0 {
+		s.corruptChunks--
+		return false, nil
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)) == sum, nil
+}
+
+func (s *Server) artifactCollection(w http.ResponseWriter, r *http.Request, a *artifact, name string) {
+	name, _ = url.PathUnescape(name)
+	idx := -1
+	for i, c := range a.collections {
+		if c == name {
+			idx = i
+		}
+	}
+	switch r.Method {
+	case "PUT":
+		if idx < 0 {
+			a.collections = append(a.collections, name)
+		}
+	case "DELETE":
+		if idx < 0 {
+			writeFault(w, http.StatusNotFound, "not_found",
+				fmt.Sprintf("artifact '%s' is not in collection '%s'", a.id, name))
+			return
+		}
+		a.collections = append(a.collections[:idx], a.collections[idx+1:]...)
+	default:
+		methodNotAllowed(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the base64 decoded value of header 'name'
+func decodeHeader(r *http.Request, name string) (string, error) {
+	v := r.Header.Get(name)
+	if v == "" {
+		return "", nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return "", fmt.Errorf("header '%s' is not base64 encoded", name)
+	}
+	return string(b), nil
+}
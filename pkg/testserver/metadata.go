@@ -0,0 +1,148 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	api "github.com/reinventingscience/ivcap-core-api/http/metadata"
+)
+
+type metaRecord struct {
+	id        string
+	entity    string
+	schema    string
+	aspect    interface{}
+	revokedAt *time.Time
+}
+
+// Handles all requests to '/1/metadata' with 'path' the remainder of the URL path
+func (s *Server) metadataHandler(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "" || path == "/" {
+		switch r.Method {
+		case "GET":
+			s.listMetadata(w, r)
+		case "POST", "PUT":
+			q := r.URL.Query()
+			entity, schema := q.Get("entity-id"), q.Get("schema")
+			if entity == "" || schema == "" {
+				writeFault(w, http.StatusBadRequest, "bad_request", "missing 'entity-id' or 'schema'")
+				return
+			}
+			if r.Method == "PUT" {
+				// update replaces all current records for this entity and schema
+				now := time.Now()
+				for _, m := range s.metadata {
+					if m.entity == entity && m.schema == schema && m.revokedAt == nil {
+						m.revokedAt = &now
+					}
+				}
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			if rec, err := s.addMetadata(entity, schema, body); err == nil {
+				writeJSON(w, http.StatusOK, &api.AddResponseBody{RecordID: strp(rec.id)})
+			} else {
+				writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+			}
+		default:
+			methodNotAllowed(w, r)
+		}
+		return
+	}
+	id, _ := url.PathUnescape(strings.TrimPrefix(path, "/"))
+	m, ok := s.metadata[id]
+	if !ok {
+		writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("metadata record '%s' not found", id))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, &api.ReadResponseBody{
+			RecordID: strp(m.id),
+			Entity:   strp(m.entity),
+			Schema:   strp(m.schema),
+			Aspect:   m.aspect,
+		})
+	case "DELETE":
+		if m.revokedAt == nil {
+			now := time.Now()
+			m.revokedAt = &now
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+// Lists all records matching the 'entity-id' and 'schema' (prefix) query
+// parameters which were valid at 'at-time' (defaults to now)
+func (s *Server) listMetadata(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	entity, schema := q.Get("entity-id"), q.Get("schema")
+	at := time.Now()
+	if ts := q.Get("at-time"); ts != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, ts); err != nil {
+			writeFault(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("invalid 'at-time' - %s", ts))
+			return
+		}
+	}
+	var ids []string
+	for _, id := range s.metadataIDs {
+		m := s.metadata[id]
+		if (entity == "" || m.entity == entity) && strings.HasPrefix(m.schema, schema) &&
+			(m.revokedAt == nil || m.revokedAt.After(at)) {
+			ids = append(ids, id)
+		}
+	}
+	ids, next := s.page(r, ids)
+	list := &api.ListResponseBody{
+		Records: []*api.MetadataListItemRTResponseBody{},
+		Links:   &api.NavTResponseBody{Self: s.selfLink(r.URL.RequestURI()), Next: next},
+	}
+	if entity != "" {
+		list.EntityID = strp(entity)
+	}
+	if schema != "" {
+		list.Schema = strp(schema)
+	}
+	for _, id := range ids {
+		m := s.metadata[id]
+		list.Records = append(list.Records, &api.MetadataListItemRTResponseBody{
+			RecordID: strp(m.id),
+			Entity:   strp(m.entity),
+			Schema:   strp(m.schema),
+			Aspect:   m.aspect,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) addMetadata(entity string, schema string, body []byte) (*metaRecord, error) {
+	var aspect interface{}
+	if err := json.Unmarshal(body, &aspect); err != nil {
+		return nil, fmt.Errorf("metadata is not valid JSON - %v", err)
+	}
+	m := &metaRecord{id: s.newID("record"), entity: entity, schema: schema, aspect: aspect}
+	s.metadata[m.id] = m
+	s.metadataIDs = append(s.metadataIDs, m.id)
+	return m, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	api "github.com/reinventingscience/ivcap-core-api/http/order"
+)
+
+const (
+	ORDER_PENDING   = "pending"
+	ORDER_EXECUTING = "executing"
+	ORDER_SUCCEEDED = "succeeded"
+	ORDER_FAILED    = "failed"
+)
+
+type order struct {
+	body *api.ReadResponseBody
+}
+
+// SetOrderStatus changes the status of order 'id', as if the order was processed
+// by the deployment. Returns false if the order doesn't exist.
+func (s *Server) SetOrderStatus(id string, status string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return false
+	}
+	now := time.Now().Format(time.RFC3339)
+	o.body.Status = strp(status)
+	switch status {
+	case ORDER_EXECUTING:
+		o.body.StartedAt = strp(now)
+	case ORDER_SUCCEEDED, ORDER_FAILED:
+		if o.body.StartedAt == nil {
+			o.body.StartedAt = strp(now)
+		}
+		o.body.FinishedAt = strp(now)
+	}
+	return true
+}
+
+// Handles all requests to '/1/orders' with 'path' the remainder of the URL path
+func (s *Server) orderHandler(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "" || path == "/" {
+		switch r.Method {
+		case "GET":
+			s.listOrders(w, r)
+		case "POST":
+			s.createOrder(w, r)
+		default:
+			methodNotAllowed(w, r)
+		}
+		return
+	}
+	id := strings.TrimPrefix(path, "/")
+	o, ok := s.orders[id]
+	if !ok {
+		writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("order '%s' not found", id))
+		return
+	}
+	if r.Method != "GET" {
+		methodNotAllowed(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, o.body)
+}
+
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
+	ids, next := s.page(r, s.orderIDs)
+	list := &api.ListResponseBody{
+		Orders: []*api.OrderListItemResponseBody{},
+		Links:  &api.NavTResponseBody{Self: s.selfLink(r.URL.RequestURI()), Next: next},
+	}
+	for _, id := range ids {
+		b := s.orders[id].body
+		list.Orders = append(list.Orders, &api.OrderListItemResponseBody{
+			ID:         b.ID,
+			Name:       b.Name,
+			Status:     b.Status,
+			OrderedAt:  b.OrderedAt,
+			StartedAt:  b.StartedAt,
+			FinishedAt: b.FinishedAt,
+			ServiceID:  b.Service.ID,
+			AccountID:  b.Account.ID,
+			Links:      b.Links,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Creates an order after checking its parameters against the service definition
+func (s *Server) createOrder(w http.ResponseWriter, r *http.Request) {
+	var req api.CreateRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFault(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("cannot parse order - %v", err))
+		return
+	}
+	svc, ok := s.services[req.ServiceID]
+	if !ok {
+		writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("service '%s' not found", req.ServiceID))
+		return
+	}
+	params := map[string]string{}
+	for _, p := range req.Parameters {
+		if p.Name == nil || p.Value == nil {
+			writeFault(w, http.StatusBadRequest, "bad_request", "parameters need a name and a value")
+			return
+		}
+		params[*p.Name] = *p.Value
+	}
+	var oparams []*api.ParameterTResponseBody
+	for _, def := range svc.body.Parameters {
+		if def.Name == nil {
+			continue
+		}
+		name := *def.Name
+		value, ok := params[name]
+		delete(params, name)
+		if !ok {
+			if def.Default != nil {
+				value = *def.Default
+			} else if def.Optional == nil || !*def.Optional {
+				writeFault(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("missing parameter '%s'", name))
+				return
+			} else {
+				continue
+			}
+		}
+		oparams = append(oparams, &api.ParameterTResponseBody{Name: strp(name), Value: strp(value)})
+	}
+	for name := range params {
+		writeFault(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("unknown parameter '%s'", name))
+		return
+	}
+
+	id := s.newID("order")
+	o := &order{body: &api.ReadResponseBody{
+		ID:         strp(id),
+		Status:     strp(ORDER_PENDING),
+		OrderedAt:  strp(time.Now().Format(time.RFC3339)),
+		Products:   []*api.ProductTResponseBody{},
+		Service:    &api.RefTResponseBody{ID: strp(req.ServiceID)},
+		Account:    &api.RefTResponseBody{ID: strp(req.AccountID)},
+		Links:      &api.SelfTResponseBody{Self: s.selfLink("/1/orders/" + id)},
+		Name:       req.Name,
+		Parameters: oparams,
+	}}
+	s.orders[id] = o
+	s.orderIDs = append(s.orderIDs, id)
+	writeJSON(w, http.StatusOK, o.body)
+}
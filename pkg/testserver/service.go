@@ -0,0 +1,144 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	api "github.com/reinventingscience/ivcap-core-api/http/service"
+)
+
+type service struct {
+	body *api.ReadResponseBody
+}
+
+// AddService registers a service as if it was created through the API and returns its ID
+func (s *Server) AddService(req *api.CreateRequestBody) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	b, _ := json.Marshal(req)
+	svc, _ := s.storeService(s.newID("service"), b)
+	return *svc.body.ID
+}
+
+// Handles all requests to '/1/services' with 'path' the remainder of the URL path
+func (s *Server) serviceHandler(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "" || path == "/" {
+		switch r.Method {
+		case "GET":
+			s.listServices(w, r)
+		case "POST":
+			body, _ := ioutil.ReadAll(r.Body)
+			if svc, err := s.storeService(s.newID("service"), body); err == nil {
+				writeJSON(w, http.StatusCreated, svc.body)
+			} else {
+				writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+			}
+		default:
+			methodNotAllowed(w, r)
+		}
+		return
+	}
+	id := strings.TrimPrefix(path, "/")
+	svc, ok := s.services[id]
+	switch r.Method {
+	case "GET":
+		if !ok {
+			writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("service '%s' not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, svc.body)
+	case "PUT":
+		if !ok && r.URL.Query().Get("force-create") != "true" {
+			writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("service '%s' not found", id))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if svc, err := s.storeService(id, body); err == nil {
+			writeJSON(w, http.StatusOK, svc.body)
+		} else {
+			writeFault(w, http.StatusBadRequest, "bad_request", err.Error())
+		}
+	case "DELETE":
+		if ok {
+			delete(s.services, id)
+			s.serviceIDs = removeID(s.serviceIDs, id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, r)
+	}
+}
+
+func (s *Server) listServices(w http.ResponseWriter, r *http.Request) {
+	ids, next := s.page(r, s.serviceIDs)
+	list := &api.ListResponseBody{
+		Services: []*api.ServiceListItemResponseBody{},
+		Links:    &api.NavTResponseBody{Self: s.selfLink(r.URL.RequestURI()), Next: next},
+	}
+	for _, id := range ids {
+		b := s.services[id].body
+		list.Services = append(list.Services, &api.ServiceListItemResponseBody{
+			ID:          b.ID,
+			Name:        b.Name,
+			Description: b.Description,
+			Provider:    b.Provider,
+			Links:       b.Links,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Creates or replaces service 'id' from a create or update request 'body'
+func (s *Server) storeService(id string, body []byte) (*service, error) {
+	var req api.CreateRequestBody
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("cannot parse service description - %v", err)
+	}
+	if req.Description == "" {
+		return nil, fmt.Errorf("missing 'description'")
+	}
+	// request and response share the same structure for all fields we keep
+	var rb api.ReadResponseBody
+	if err := json.Unmarshal(body, &rb); err != nil {
+		return nil, fmt.Errorf("cannot parse service description - %v", err)
+	}
+	rb.ID = strp(id)
+	rb.Status = strp("active")
+	rb.Links = &api.SelfTResponseBody{Self: s.selfLink("/1/services/" + id)}
+	rb.Account = &api.RefTResponseBody{ID: strp(TEST_ACCOUNT_ID)}
+	if req.ProviderID != "" {
+		rb.Provider = &api.RefTResponseBody{ID: strp(req.ProviderID)}
+	}
+	svc := &service{body: &rb}
+	if _, exists := s.services[id]; !exists {
+		s.serviceIDs = append(s.serviceIDs, id)
+	}
+	s.services[id] = svc
+	return svc, nil
+}
+
+func removeID(ids []string, id string) []string {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testserver provides an in-process fake of the IVCAP API with
+// in-memory state, to be used in integration tests of the SDK and the CLI.
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Account all resources created on the server are billed to
+const TEST_ACCOUNT_ID = "urn:ivcap:account:00000000-0000-0000-0000-000000000000"
+
+// Server is a fake IVCAP deployment. All state is kept in memory and
+// lost when the server is closed.
+type Server struct {
+	*httptest.Server
+	// If set, all API requests need to carry this bearer token
+	AccessToken   string
+	lock          sync.Mutex
+	lastID        int
+	corruptChunks int
+	noConcat      bool
+	downloadDelay time.Duration
+	artifacts     map[string]*artifact
+	partials      map[string]*artifact // partial uploads waiting to be concatenated
+	orders        map[string]*order
+	services      map[string]*service
+	metadata      map[string]*metaRecord
+	// IDs in the order resources were created in, to keep lists stable
+	artifactIDs []string
+	orderIDs    []string
+	serviceIDs  []string
+	metadataIDs []string
+}
+
+// New starts a new server. It needs to be closed by the caller.
+func New() *Server {
+	s := &Server{
+		artifacts: map[string]*artifact{},
+		partials:  map[string]*artifact{},
+		orders:    map[string]*order{},
+		services:  map[string]*service{},
+		metadata:  map[string]*metaRecord{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if path == "/1/authinfo.yaml" {
+		s.authInfo(w, r)
+		return
+	}
+	if s.AccessToken != "" && r.Header.Get("Authorization") != "Bearer "+s.AccessToken {
+		writeFault(w, http.StatusUnauthorized, "unauthorized", "missing or invalid access token")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case strings.HasPrefix(path, "/1/artifacts"):
+		s.artifactHandler(w, r, strings.TrimPrefix(path, "/1/artifacts"))
+	case strings.HasPrefix(path, "/1/orders"):
+		s.orderHandler(w, r, strings.TrimPrefix(path, "/1/orders"))
+	case strings.HasPrefix(path, "/1/services"):
+		s.serviceHandler(w, r, strings.TrimPrefix(path, "/1/services"))
+	case strings.HasPrefix(path, "/1/metadata"):
+		s.metadataHandler(w, r, strings.TrimPrefix(path, "/1/metadata"))
+	default:
+		writeFault(w, http.StatusNotFound, "not_found", fmt.Sprintf("unknown path '%s'", path))
+	}
+}
+
+// Describes a login provider whose endpoints are hosted on this server. They are
+// not implemented, but allow testing the retrieval of the auth info.
+func (s *Server) authInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	fmt.Fprintf(w, `version: 1
+auth:
+  default-provider-id: test
+  providers:
+    test:
+      login-url: %[1]s/oauth/login
+      token-url: %[1]s/oauth/token
+      code-url: %[1]s/oauth/device/code
+      jwks-url: %[1]s/oauth/jwks.json
+      client-id: test-client
+`, s.URL)
+}
+
+// Returns a new ID for a resource of type 'kind'. IDs are deterministic to
+// simplify comparing outputs in tests.
+func (s *Server) newID(kind string) string {
+	s.lastID++
+	return fmt.Sprintf("urn:ivcap:%s:00000000-0000-0000-0000-%012d", kind, s.lastID)
+}
+
+func (s *Server) selfLink(path string) *string {
+	l := s.URL + path
+	return &l
+}
+
+// Returns the slice of 'ids' selected by the 'offset' and 'limit'
+// query parameters, as well as the link to the next page if there is one.
+func (s *Server) page(r *http.Request, ids []string) ([]string, *string) {
+	q := r.URL.Query()
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if offset < 0 || offset > len(ids) {
+		offset = len(ids)
+	}
+	end := len(ids)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	var next *string
+	if end < len(ids) {
+		q.Set("offset", strconv.Itoa(end))
+		next = s.selfLink(r.URL.Path + "?" + q.Encode())
+	}
+	return ids[offset:end], next
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Replies with an error in the same (Goa) format used by the IVCAP API
+func writeFault(w http.ResponseWriter, status int, name string, msg string) {
+	writeJSON(w, status, map[string]interface{}{
+		"name":      name,
+		"id":        fmt.Sprintf("test-%d", status),
+		"message":   msg,
+		"temporary": false,
+		"timeout":   false,
+		"fault":     status >= 500,
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeFault(w, http.StatusMethodNotAllowed, "method_not_allowed",
+		fmt.Sprintf("method '%s' not supported for '%s'", r.Method, r.URL.Path))
+}
+
+func strp(s string) *string { return &s }
@@ -0,0 +1,144 @@
+// Copyright 2023 Commonwealth Scientific and Industrial Research Organisation (CSIRO) ABN 41 687 119 230
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reinventingscience/ivcap-cli/pkg/adapter"
+)
+
+// Max. number of bytes a rate limited reader returns at once, which keeps the transfer smooth
+const RATE_LIMIT_MAX_READ = 32 * 1024
+
+// TransferStats collects what uploads and downloads made with the context
+// returned by WithTransferStats transferred.
+type TransferStats struct {
+	Bytes   int64 // content bytes read or written, including any sent again
+	Retries int64 // requests and chunks sent again, or downloads resumed
+	Started time.Time
+
+	limiter *rateLimiter
+}
+
+// Elapsed returns the time since the transfer started
+func (s *TransferStats) Elapsed() time.Duration {
+	return time.Since(s.Started)
+}
+
+// Throughput returns the average number of bytes transferred per second
+func (s *TransferStats) Throughput() float64 {
+	secs := s.Elapsed().Seconds()
+	if secs <= 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&s.Bytes)) / secs
+}
+
+type transferStatsKey struct{}
+
+// WithTransferStats returns a context which makes all uploads and downloads using
+// it update the returned stats. If 'rateLimit' is > 0, they also share a limit of
+// that many bytes per second.
+func WithTransferStats(ctxt context.Context, rateLimit int64) (context.Context, *TransferStats) {
+	stats := &TransferStats{Started: time.Now()}
+	if rateLimit > 0 {
+		stats.limiter = &rateLimiter{rate: float64(rateLimit), last: stats.Started}
+	}
+	ctxt = adapter.WithRetryCounter(ctxt, &stats.Retries)
+	return context.WithValue(ctxt, transferStatsKey{}, stats), stats
+}
+
+// TransferReader returns a reader which counts the bytes read from 'r' for, and
+// limits their rate to, the transfer stats of 'ctxt'. It returns 'r' if there are none.
+// If 'r' is an io.Seeker, so is the returned reader, which lets the adapter send
+// request bodies again. Bytes read again after seeking back are counted again.
+func TransferReader(ctxt context.Context, r io.Reader) io.Reader {
+	stats, ok := ctxt.Value(transferStatsKey{}).(*TransferStats)
+	if !ok {
+		return r
+	}
+	t := &transferReader{ctxt: ctxt, r: r, stats: stats}
+	if seeker, ok := r.(io.Seeker); ok {
+		return &transferReadSeeker{t, seeker}
+	}
+	return t
+}
+
+type transferReader struct {
+	ctxt  context.Context
+	r     io.Reader
+	stats *TransferStats
+}
+
+func (t *transferReader) Read(p []byte) (int, error) {
+	if t.stats.limiter != nil && len(p) > RATE_LIMIT_MAX_READ {
+		p = p[:RATE_LIMIT_MAX_READ]
+	}
+	n, err := t.r.Read(p)
+	atomic.AddInt64(&t.stats.Bytes, int64(n))
+	if t.stats.limiter != nil && n > 0 {
+		if werr := t.stats.limiter.wait(t.ctxt, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+type transferReadSeeker struct {
+	*transferReader
+	seeker io.Seeker
+}
+
+func (t *transferReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return t.seeker.Seek(offset, whence)
+}
+
+// Token bucket allowing 'rate' bytes per second, with bursts of up to a second
+type rateLimiter struct {
+	rate  float64
+	lock  sync.Mutex
+	avail float64 // may become negative, which callers then wait off
+	last  time.Time
+}
+
+// Takes 'n' bytes from the bucket and waits until they are covered by the rate
+func (l *rateLimiter) wait(ctxt context.Context, n int) error {
+	l.lock.Lock()
+	now := time.Now()
+	l.avail += now.Sub(l.last).Seconds() * l.rate
+	if l.avail > l.rate {
+		l.avail = l.rate
+	}
+	l.last = now
+	l.avail -= float64(n)
+	delay := time.Duration(-l.avail / l.rate * float64(time.Second))
+	l.lock.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctxt.Done():
+		return ctxt.Err()
+	}
+}